@@ -0,0 +1,184 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ack keeps track of acknowledgements, the objects a receiver
+// writes into a share folder once it has the share.
+package ack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	encrypt "github.com/jemurai/s3s2/encrypt"
+)
+
+// Dir is the folder in a share that acknowledgements are written to.
+const Dir = "s3s2_acks"
+
+// How a file of the share turned out for the receiver.
+const (
+	// Verified files matched the hash in the manifest.
+	Verified = "verified"
+	// Received files were decrypted but the sender did not hash them.
+	Received = "received"
+	// Failed files could not be downloaded, decrypted or verified.
+	Failed = "failed"
+)
+
+// How much of a share was delivered, from its acknowledgements.
+const (
+	Delivered    = "delivered"
+	Partial      = "partially delivered"
+	NotRetrieved = "not yet retrieved"
+)
+
+// File is one file of the share and how it turned out.
+type File struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Ack is what a receiver got of a share, signed with the receiver's key.
+type Ack struct {
+	Folder         string    `json:"folder"`
+	ManifestDigest string    `json:"manifest_digest"`
+	Receiver       string    `json:"receiver"`
+	Received       time.Time `json:"received"`
+	Files          []File    `json:"files"`
+	// Signature is the receiver's armored signature of the rest of
+	// the ack, and Signer the fingerprint of the key that made it.
+	Signature string `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+}
+
+// Prefix is where the acknowledgements of a share are kept.
+func Prefix(folder string) string {
+	return path.Join(folder, Dir) + "/"
+}
+
+// IsKey reports whether an object is an acknowledgement.
+func IsKey(key string) bool {
+	return strings.Contains("/"+key, "/"+Dir+"/")
+}
+
+// Key is where the ack is written.  Each receipt gets its own object, so
+// a share decrypted again, or by another receiver, adds to the record.
+func (a Ack) Key() string {
+	signer := a.Signer
+	if len(signer) > 16 {
+		signer = signer[len(signer)-16:]
+	}
+	return fmt.Sprintf("%s%s-%s.json", Prefix(a.Folder), a.Received.UTC().Format("20060102T150405Z"), signer)
+}
+
+// Parse an ack from the contents of its object.
+func Parse(data []byte) (Ack, error) {
+	var a Ack
+	err := json.Unmarshal(data, &a)
+	return a, err
+}
+
+// payload is what the signature covers: everything else.
+func (a Ack) payload() []byte {
+	a.Signature, a.Signer = "", ""
+	data, _ := json.Marshal(a)
+	return data
+}
+
+// Sign the ack with the receiver's keys.
+func (a *Ack) Sign(pubkey string, privkey string) error {
+	signer, err := encrypt.Fingerprint(pubkey)
+	if err != nil {
+		return err
+	}
+	signature, err := encrypt.Sign(a.payload(), pubkey, privkey)
+	if err != nil {
+		return err
+	}
+	a.Signature, a.Signer = signature, signer
+	return nil
+}
+
+// Verify that the ack was signed by the holder of the public key.
+func (a Ack) Verify(pubkey string) error {
+	if a.Signature == "" {
+		return errors.New("the ack is not signed")
+	}
+	fingerprint, err := encrypt.Fingerprint(pubkey)
+	if err != nil {
+		return err
+	}
+	if a.Signer != fingerprint {
+		return fmt.Errorf("the ack is signed by %s, not %s", a.Signer, fingerprint)
+	}
+	if err := encrypt.Verify(a.payload(), a.Signature, pubkey); err != nil {
+		return fmt.Errorf("the ack signature is not valid, %v", err)
+	}
+	return nil
+}
+
+// For reports whether the ack was written for the share in folder and,
+// when digest is given, for that version of its manifest.  A valid
+// signature alone does not tie an ack to a share: an ack copied in from
+// another share, or left from an earlier version of this one, still
+// verifies.
+func (a Ack) For(folder string, digest string) bool {
+	return a.Folder == folder && (digest == "" || a.ManifestDigest == digest)
+}
+
+// Delivery is how much of a share its acks account for.
+type Delivery struct {
+	Status string `json:"status"`
+	// Delivered files were verified or received in some ack, Failed
+	// files only ever failed and Missing files are in no ack at all.
+	Delivered []string `json:"delivered"`
+	Failed    []string `json:"failed,omitempty"`
+	Missing   []string `json:"missing,omitempty"`
+}
+
+// Deliver works out the delivery of the named files from the acks.
+func Deliver(acks []Ack, names []string) Delivery {
+	best := map[string]string{}
+	for _, a := range acks {
+		for _, f := range a.Files {
+			if best[f.Name] != Verified && best[f.Name] != Received {
+				best[f.Name] = f.Status
+			}
+		}
+	}
+	var d Delivery
+	for _, name := range names {
+		switch best[name] {
+		case Verified, Received:
+			d.Delivered = append(d.Delivered, name)
+		case Failed:
+			d.Failed = append(d.Failed, name)
+		default:
+			d.Missing = append(d.Missing, name)
+		}
+	}
+	switch {
+	case len(d.Delivered) == 0:
+		d.Status = NotRetrieved
+	case len(d.Delivered) == len(names):
+		d.Status = Delivered
+	default:
+		d.Status = Partial
+	}
+	return d
+}
@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/ack"
+	"github.com/jemurai/s3s2/encrypt"
+)
+
+var _ = Describe("Acknowledgements", func() {
+	var (
+		dir       string
+		pub, priv string
+		a         ack.Ack
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-ack")
+		encrypt.GenerateKeys(dir, "receiver", 1024)
+		pub = filepath.Join(dir, "receiver.pubkey")
+		priv = filepath.Join(dir, "receiver.privkey")
+		a = ack.Ack{
+			Folder:         "acme_s3s2_1",
+			ManifestDigest: "abc",
+			Receiver:       "receiver@host",
+			Received:       time.Date(2019, 6, 30, 12, 0, 0, 0, time.UTC),
+			Files: []ack.File{
+				{Name: "a.csv", Status: ack.Verified},
+				{Name: "b.csv", Status: ack.Failed},
+			},
+		}
+		Expect(a.Sign(pub, priv)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should write each ack into the share's ack folder", func() {
+		Expect(ack.IsKey(a.Key())).To(BeTrue())
+		Expect(strings.HasPrefix(a.Key(), "acme_s3s2_1/s3s2_acks/20190630T120000Z-")).To(BeTrue())
+	})
+
+	It("should verify an ack from the receiver after a round trip", func() {
+		data, _ := json.Marshal(a)
+		read, err := ack.Parse(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.Verify(pub)).To(Succeed())
+	})
+
+	It("should reject an ack that was changed or signed by another key", func() {
+		changed := a
+		changed.Files = []ack.File{{Name: "a.csv", Status: ack.Verified}, {Name: "b.csv", Status: ack.Verified}}
+		Expect(changed.Verify(pub)).To(MatchError(ContainSubstring("signature")))
+
+		encrypt.GenerateKeys(dir, "other", 1024)
+		Expect(a.Verify(filepath.Join(dir, "other.pubkey"))).To(MatchError(ContainSubstring("signed by")))
+
+		a.Signature = ""
+		Expect(a.Verify(pub)).To(MatchError(ContainSubstring("not signed")))
+	})
+
+	It("should tell a replayed ack from one written for the share", func() {
+		Expect(a.For("acme_s3s2_1", "")).To(BeTrue())
+		Expect(a.For("acme_s3s2_1", "abc")).To(BeTrue())
+
+		replayed := a
+		Expect(replayed.Verify(pub)).To(Succeed())
+		Expect(replayed.For("acme_s3s2_2", "")).To(BeFalse())
+		Expect(replayed.For("acme_s3s2_2", "abc")).To(BeFalse())
+
+		Expect(a.For("acme_s3s2_1", "def")).To(BeFalse())
+	})
+
+	It("should work out the delivery from every ack", func() {
+		names := []string{"a.csv", "b.csv"}
+		Expect(ack.Deliver(nil, names).Status).To(Equal(ack.NotRetrieved))
+
+		d := ack.Deliver([]ack.Ack{a}, names)
+		Expect(d.Status).To(Equal(ack.Partial))
+		Expect(d.Delivered).To(Equal([]string{"a.csv"}))
+		Expect(d.Failed).To(Equal([]string{"b.csv"}))
+
+		retry := ack.Ack{Files: []ack.File{{Name: "b.csv", Status: ack.Received}}}
+		d = ack.Deliver([]ack.Ack{a, retry}, append(names, "c.csv"))
+		Expect(d.Status).To(Equal(ack.Partial))
+		Expect(d.Missing).To(Equal([]string{"c.csv"}))
+
+		d = ack.Deliver([]ack.Ack{retry, a}, names)
+		Expect(d.Status).To(Equal(ack.Delivered))
+		Expect(d.Failed).To(BeEmpty())
+	})
+})
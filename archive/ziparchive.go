@@ -17,6 +17,7 @@ package archive
 import (
 	"github.com/jemurai/s3s2/options"
 	"archive/zip"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -28,33 +29,33 @@ import (
 // ZipFile archives the provided list of files into a Zip file.
 // This is functional but not currently used in S3S2 in favor of
 // the Zst archive format which is faster and better compression.
-func ZipFile(filename string, options options.Options) string {
+// It returns the name of the zip file, which may be left half written
+// if there is an error.
+func ZipFile(filename string, options options.Options) (string, error) {
 	zfilename := filename + ".zip"
-	log.Debugf("The file name is " + zfilename)
-	newZipFile, err := os.Create(zfilename)
-	if err != nil {
-		log.Error(err)
+	if options.WorkDir != "" {
+		zfilename = filepath.Join(options.WorkDir, strings.Replace(filename, options.Directory, "", -1)) + ".zip"
+		if err := os.MkdirAll(filepath.Dir(zfilename), 0700); err != nil {
+			return zfilename, err
+		}
 	}
-	defer newZipFile.Close()
-
-	zipWriter := zip.NewWriter(newZipFile)
-	defer zipWriter.Close()
+	log.Debugf("The file name is " + zfilename)
 
 	zipfile, err := os.Open(filename)
 	if err != nil {
-		log.Error(err)
+		return zfilename, err
 	}
 	defer zipfile.Close()
 
 	// Get the file information
 	info, err := zipfile.Stat()
 	if err != nil {
-		log.Error(err)
+		return zfilename, err
 	}
 
 	header, err := zip.FileInfoHeader(info)
 	if err != nil {
-		log.Error(err)
+		return zfilename, fmt.Errorf("unable to zip %s, %v", filename, err)
 	}
 
 	// Using FileInfoHeader() above only uses the basename of the file. If we want
@@ -65,68 +66,91 @@ func ZipFile(filename string, options options.Options) string {
 	// see http://golang.org/pkg/archive/zip/#pkg-constants
 	header.Method = zip.Deflate
 
+	newZipFile, err := os.Create(zfilename)
+	if err != nil {
+		return zfilename, err
+	}
+	defer newZipFile.Close()
+
+	zipWriter := zip.NewWriter(newZipFile)
 	writer, err := zipWriter.CreateHeader(header)
 	if err != nil {
-		log.Error(err)
+		return zfilename, fmt.Errorf("unable to zip %s, %v", filename, err)
 	}
 	if _, err = io.Copy(writer, zipfile); err != nil {
-		log.Error(err)
+		return zfilename, fmt.Errorf("unable to zip %s, %v", filename, err)
 	}
-	return zfilename
+	if err := zipWriter.Close(); err != nil {
+		return zfilename, fmt.Errorf("unable to zip %s, %v", filename, err)
+	}
+	return zfilename, newZipFile.Close()
 }
 
-// UnZipFile uncompresses and archive
-func UnZipFile(filename string, destination string) string {
+// UnZipFile uncompresses an archive into the destination and returns
+// the last file extracted.  Entries that would land outside the
+// destination are refused.
+func UnZipFile(filename string, destination string) (string, error) {
 	log.Debugf("Unzipping file %s", filename)
 	returnFn := filename
 	if !strings.HasSuffix(filename, ".zip") {
 		log.Warnf("Skipping file because it is not a zip file, %s", filename)
-		return returnFn
+		return returnFn, nil
 	}
 
 	zReader, err := zip.OpenReader(filename)
 	if err != nil {
-		log.Error(err)
+		return returnFn, fmt.Errorf("unable to unzip %s, %v", filename, err)
 	}
 	defer zReader.Close()
 	for _, file := range zReader.Reader.File {
-
-		zippedFile, err := file.Open()
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer zippedFile.Close()
 		log.Debugf("this is the files name from zreader " + file.Name)
 		extractedFilePath := filepath.Join(
 			destination,
 			file.Name,
 		)
+		if rel, err := filepath.Rel(filepath.Clean(destination), extractedFilePath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return returnFn, fmt.Errorf("refusing to unzip %s from %s outside of %s", file.Name, filename, destination)
+		}
 		log.Debugf("\tExtracted path: %s", extractedFilePath)
 		if file.FileInfo().IsDir() {
 			log.Println("Directory Created:", extractedFilePath)
-			os.MkdirAll(extractedFilePath, file.Mode())
-		} else {
-			log.Println("\tFile extracted:", file.Name)
-
-			extractDir := filepath.Dir(extractedFilePath)
-			os.MkdirAll(extractDir, os.ModePerm)
-			outputFile, err := os.OpenFile(
-				extractedFilePath,
-				os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-				file.Mode(),
-			)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			_, err = io.Copy(outputFile, zippedFile)
-			if err != nil {
-				log.Fatal(err)
+			if err := os.MkdirAll(extractedFilePath, file.Mode()); err != nil {
+				return returnFn, err
 			}
-			returnFn = outputFile.Name()
-			outputFile.Close()
+			continue
+		}
+		log.Println("\tFile extracted:", file.Name)
+		if err := extract(file, extractedFilePath); err != nil {
+			return returnFn, fmt.Errorf("unable to unzip %s from %s, %v", file.Name, filename, err)
 		}
+		returnFn = extractedFilePath
 	}
 	log.Debugf("\tUnzip returning file name %s", returnFn)
-	return returnFn
+	return returnFn, nil
+}
+
+// extract writes a single file of an archive to a path.
+func extract(file *zip.File, path string) error {
+	zippedFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer zippedFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	outputFile, err := os.OpenFile(
+		path,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		file.Mode(),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outputFile, zippedFile); err != nil {
+		outputFile.Close()
+		return err
+	}
+	return outputFile.Close()
 }
@@ -0,0 +1,319 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// Actions recorded in the ledger.
+const (
+	Share   = "share"
+	Decrypt = "decrypt"
+	Verify  = "verify"
+	Purge   = "purge"
+)
+
+// Outcomes recorded in the ledger.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Prefix is where the ledger is mirrored in the bucket.
+const Prefix = "_audit/"
+
+// Entry is a single action in the ledger.  Each entry commits to the
+// one before it through Prev, so an edited or removed entry breaks
+// the chain from that point on.
+type Entry struct {
+	Seq            int64     `json:"seq"`
+	Timestamp      time.Time `json:"timestamp"`
+	Actor          string    `json:"actor"`
+	Action         string    `json:"action"`
+	ShareID        string    `json:"share_id"`
+	ManifestDigest string    `json:"manifest_digest,omitempty"`
+	Outcome        string    `json:"outcome"`
+	Detail         string    `json:"detail,omitempty"`
+	Prev           string    `json:"prev"`
+	Hash           string    `json:"hash"`
+}
+
+// ComputeHash hashes everything in the entry except the hash itself.
+func (e Entry) ComputeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Key is the object key an entry is mirrored to in the bucket.  Entries
+// are grouped by ledger, named after the hash of its first entry.
+func (e Entry) Key(ledger string) string {
+	return fmt.Sprintf("%s%s/%012d-%s.json", Prefix, ledger, e.Seq, e.Hash)
+}
+
+// lockWait is how long Append waits for another s3s2 appending to the
+// same ledger, and lockStale how old a lock must be to be taken over
+// from one that died holding it.
+const (
+	lockWait  = 30 * time.Second
+	lockStale = time.Minute
+)
+
+// Ledger is an append only, hash chained file of entries.  Appends are
+// serialized within the process by mu and across processes by a lock
+// file next to the ledger.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open a ledger at a path.  The file is created on the first append.
+func Open(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// DefaultPath is the ledger in the user's home directory.
+func DefaultPath() string {
+	usr, err := user.Current()
+	if err != nil {
+		return ".s3s2_audit.log"
+	}
+	return filepath.Join(usr.HomeDir, ".s3s2", "audit.log")
+}
+
+// Actor names the user running s3s2, including the sudo user if any.
+func Actor() string {
+	name := "unknown"
+	if usr, err := user.Current(); err == nil {
+		name = usr.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name = name + "@" + host
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		name = name + " (sudo " + sudoUser + ")"
+	}
+	return name
+}
+
+// Append chains an entry onto the end of the ledger and returns it
+// with its sequence number and hashes filled in.
+func (l *Ledger) Append(e Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	unlock, err := l.lock()
+	if err != nil {
+		return e, err
+	}
+	defer unlock()
+
+	entries, err := l.Entries()
+	if err != nil {
+		return e, err
+	}
+	e.Seq = 1
+	e.Prev = ""
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		e.Seq = last.Seq + 1
+		e.Prev = last.Hash
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	if e.Actor == "" {
+		e.Actor = Actor()
+	}
+	e.Hash = e.ComputeHash()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+	data, _ := json.Marshal(e)
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// lock takes the lock file of the ledger, so that a receive running as
+// a service and a share run by hand do not give two entries the same
+// sequence.  It is a plain file rather than a file lock so that it works
+// the same on every platform.
+func (l *Ledger) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return nil, err
+	}
+	name := l.path + ".lock"
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if stat, err := os.Stat(name); err == nil && time.Since(stat.ModTime()) > lockStale {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the audit ledger is locked by another s3s2, remove %s if none is running", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Entries reads every entry in the ledger.
+func (l *Ledger) Entries() ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("unreadable ledger entry after seq %d, %v", len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// ID names the ledger after the hash of its first entry.
+func ID(entries []Entry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[0].Hash
+}
+
+// VerifyChain checks that the ledger starts at the first entry, that
+// every entry hashes correctly, that the sequence has no gaps and that
+// each entry points at the one before.
+func VerifyChain(entries []Entry) error {
+	if len(entries) > 0 && (entries[0].Seq != 1 || entries[0].Prev != "") {
+		return fmt.Errorf("the ledger starts at entry %d, earlier entries are missing", entries[0].Seq)
+	}
+	return VerifyTail(entries)
+}
+
+// VerifyTail checks a run of entries the way VerifyChain does, except
+// that it may start anywhere in the ledger, as a mirror that was started
+// after the ledger does.
+func VerifyTail(entries []Entry) error {
+	for i, e := range entries {
+		if i > 0 && e.Seq != entries[i-1].Seq+1 {
+			return fmt.Errorf("entry %d follows entry %d, entries are missing or out of order", e.Seq, entries[i-1].Seq)
+		}
+		if i > 0 && e.Prev != entries[i-1].Hash {
+			return fmt.Errorf("entry %d does not follow entry %d", e.Seq, e.Seq-1)
+		}
+		if e.ComputeHash() != e.Hash {
+			return fmt.Errorf("entry %d has been modified", e.Seq)
+		}
+	}
+	return nil
+}
+
+// Compare checks a mirror, which must verify as a tail on its own,
+// against the local ledger it was copied from.  Every mirrored entry
+// must be in the local ledger as it is.  Local entries after the end of
+// the mirror are not an error, they are mirrored on the next append.
+func Compare(local []Entry, mirror []Entry) error {
+	for _, m := range mirror {
+		if m.Seq < 1 || m.Seq > int64(len(local)) {
+			return fmt.Errorf("local ledger has been truncated, the bucket has entry %d and the local ledger %d entries", m.Seq, len(local))
+		}
+		if local[m.Seq-1].Hash != m.Hash {
+			return fmt.Errorf("entry %d differs between the local ledger and the bucket", m.Seq)
+		}
+	}
+	return nil
+}
+
+// Mirror copies the entries of the local ledger that the store does not
+// have yet.  Entries recorded without the store at hand, or whose copy
+// failed, are filled in this way by the next append that has it.  It
+// copies in order and stops at the first failure, so the mirror never
+// has a gap.
+func Mirror(store storage.Storage, entries []Entry) error {
+	id := ID(entries)
+	objects, err := store.List(Prefix + id + "/")
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, o := range objects {
+		have[o.Key] = true
+	}
+	for _, e := range entries {
+		if key := e.Key(id); !have[key] {
+			data, _ := json.Marshal(e)
+			if _, err := storage.WriteObject(store, key, data, storage.PutOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadMirror reads the mirrored entries of a ledger back from the store,
+// in order.
+func ReadMirror(store storage.Storage, id string) ([]Entry, error) {
+	objects, err := store.List(Prefix + id + "/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	var entries []Entry
+	for _, o := range objects {
+		data, err := storage.ReadObject(store, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,103 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/audit"
+	"github.com/jemurai/s3s2/storage"
+)
+
+var _ = Describe("Audit ledger", func() {
+	var (
+		dir     string
+		ledger  *audit.Ledger
+		entries []audit.Entry
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-audit")
+		ledger = audit.Open(filepath.Join(dir, "audit.log"))
+		for _, action := range []string{audit.Share, audit.Verify, audit.Decrypt} {
+			_, err := ledger.Append(audit.Entry{Action: action, ShareID: "share", Outcome: audit.Success})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		entries, _ = ledger.Entries()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should chain the entries", func() {
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[1].Prev).To(Equal(entries[0].Hash))
+		Expect(audit.VerifyChain(entries)).To(Succeed())
+	})
+
+	It("should detect an edited entry", func() {
+		entries[1].Outcome = audit.Failure
+		Expect(audit.VerifyChain(entries)).NotTo(Succeed())
+	})
+
+	It("should detect a removed entry", func() {
+		Expect(audit.VerifyChain([]audit.Entry{entries[0], entries[2]})).NotTo(Succeed())
+	})
+
+	It("should detect truncation against the mirror", func() {
+		Expect(audit.Compare(entries[:2], entries)).NotTo(Succeed())
+		Expect(audit.Compare(entries, entries)).To(Succeed())
+		Expect(audit.Compare(nil, entries)).NotTo(Succeed())
+	})
+
+	It("should accept a mirror that is a tail of the ledger", func() {
+		Expect(audit.VerifyChain(entries[1:])).NotTo(Succeed())
+		Expect(audit.VerifyTail(entries[1:])).To(Succeed())
+		Expect(audit.Compare(entries, entries[1:])).To(Succeed())
+		Expect(audit.Compare(entries, entries[:2])).To(Succeed())
+	})
+
+	It("should fill a gap in the mirror on the next append", func() {
+		store := storage.NewLocal(filepath.Join(dir, "bucket"))
+		Expect(audit.Mirror(store, entries)).To(Succeed())
+		// The copy of the second entry was lost, say to a brief S3 error.
+		Expect(store.Delete(entries[1].Key(audit.ID(entries)))).To(Succeed())
+		mirror, err := audit.ReadMirror(store, audit.ID(entries))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit.VerifyTail(mirror)).NotTo(Succeed())
+
+		_, err = ledger.Append(audit.Entry{Action: audit.Verify, ShareID: "share", Outcome: audit.Success})
+		Expect(err).NotTo(HaveOccurred())
+		entries, _ = ledger.Entries()
+		Expect(audit.Mirror(store, entries)).To(Succeed())
+		mirror, err = audit.ReadMirror(store, audit.ID(entries))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mirror).To(HaveLen(4))
+		Expect(audit.VerifyTail(mirror)).To(Succeed())
+		Expect(audit.Compare(entries, mirror)).To(Succeed())
+	})
+
+	It("should give each append its own sequence across ledgers on the same file", func() {
+		other := audit.Open(filepath.Join(dir, "audit.log"))
+		done := make(chan struct{})
+		for _, l := range []*audit.Ledger{ledger, other} {
+			go func(l *audit.Ledger) {
+				defer GinkgoRecover()
+				for i := 0; i < 10; i++ {
+					_, err := l.Append(audit.Entry{Action: audit.Share, ShareID: "share", Outcome: audit.Success})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				done <- struct{}{}
+			}(l)
+		}
+		<-done
+		<-done
+		entries, _ = ledger.Entries()
+		Expect(entries).To(HaveLen(23))
+		Expect(audit.VerifyChain(entries)).To(Succeed())
+	})
+})
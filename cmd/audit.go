@@ -0,0 +1,144 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	audit "github.com/jemurai/s3s2/audit"
+	options "github.com/jemurai/s3s2/options"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Work with the audit ledger",
+	Long: `Work with the audit ledger.
+
+Every share, decrypt, verify and purge is recorded in an append only
+ledger where each entry commits to the hash of the one before it.  The
+ledger is kept locally (see --ledger) and mirrored to the _audit/ prefix
+of each bucket s3s2 works with.  Entries recorded without a bucket, or
+whose copy failed, are copied by the next action with the bucket.`,
+}
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit ledger for edits and truncation",
+	Long: `Check the audit ledger for edits and truncation.
+
+The local ledger is checked on its own first, and an empty one fails.
+If a bucket is given, the mirrored copy is checked too: it must be an
+unbroken run of entries that the local ledger has as they are, which
+also finds entries removed from the end of the local ledger.  Local
+entries the bucket does not have yet are only warned about.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+		}
+		r := &report{}
+
+		entries, err := ledger().Entries()
+		if err != nil {
+			r.fail("Unable to read the local ledger, %v", err)
+		} else if len(entries) == 0 {
+			r.fail("Local ledger has no entries, it is missing or was emptied.")
+		} else if err := audit.VerifyChain(entries); err != nil {
+			r.fail("Local ledger: %v", err)
+		} else {
+			r.pass("Local ledger has %d entries and an unbroken chain.", len(entries))
+		}
+
+		if hasStorage(opts) && r.failures == 0 {
+			mirror, err := audit.ReadMirror(openStorage(opts), audit.ID(entries))
+			switch {
+			case err != nil:
+				r.fail("Unable to read the ledger mirror, %v", err)
+			case len(mirror) == 0:
+				r.warn("The bucket has no copy of the ledger yet, it is copied by the next action with the bucket.")
+			default:
+				first, last := mirror[0].Seq, mirror[len(mirror)-1].Seq
+				if err := audit.VerifyTail(mirror); err != nil {
+					r.fail("Bucket ledger: %v", err)
+				} else if err := audit.Compare(entries, mirror); err != nil {
+					r.fail("%v", err)
+				} else if last < int64(len(entries)) {
+					r.pass("Bucket ledger matches entries %d to %d of the local ledger.", first, last)
+					r.warn("The bucket does not have entries %d to %d yet, they are copied by the next action with the bucket.", last+1, len(entries))
+				} else {
+					r.pass("Bucket ledger matches entries %d to %d of the local ledger.", first, last)
+				}
+			}
+		}
+
+		r.summary()
+		if r.failures > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	auditLedger     *audit.Ledger
+	openAuditLedger sync.Once
+)
+
+// ledger is the local audit ledger.  It is opened once, so that files
+// and shares worked on at the same time append to it one at a time.
+func ledger() *audit.Ledger {
+	openAuditLedger.Do(func() {
+		path := viper.GetString("ledger")
+		if path == "" {
+			path = audit.DefaultPath()
+		}
+		auditLedger = audit.Open(path)
+	})
+	return auditLedger
+}
+
+// recordAudit appends an entry to the local ledger and mirrors the
+// ledger to the bucket, copying any entries the bucket is missing.
+// Problems are logged rather than failing the action itself.
+func recordAudit(e audit.Entry, options options.Options) {
+	l := ledger()
+	if _, err := l.Append(e); err != nil {
+		log.Warnf("Unable to write to the audit ledger, %v", err)
+		return
+	}
+	if !hasStorage(options) {
+		return
+	}
+	entries, err := l.Entries()
+	if err != nil {
+		log.Warnf("Unable to read the audit ledger, %v", err)
+		return
+	}
+	if err := audit.Mirror(openStorage(options), entries); err != nil {
+		log.Warnf("Unable to mirror the audit ledger, the next action with the bucket copies what is missing, %v", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+}
@@ -0,0 +1,128 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	options "github.com/jemurai/s3s2/options"
+	resume "github.com/jemurai/s3s2/resume"
+	s3helper "github.com/jemurai/s3s2/s3"
+)
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Abort orphaned multipart uploads in the bucket",
+	Long: `Abort orphaned multipart uploads in the bucket.
+
+A share that is stopped part way leaves its multipart uploads open
+so that running it again can resume them.  S3 keeps, and charges
+for, the parts of open uploads until they are completed or aborted.
+cleanup aborts the uploads older than --older-than, except the ones
+this machine can still resume unless --all is given, and forgets
+local upload state for uploads that are gone from the bucket.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix, _ := cmd.Flags().GetString("prefix")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		all, _ := cmd.Flags().GetBool("all")
+		dry, _ := cmd.Flags().GetBool("dry-run")
+
+		opts := withConnection(options.Options{
+			Bucket: viper.GetString("bucket"),
+			Region: viper.GetString("region"),
+		})
+		if opts.Bucket == "" {
+			log.Fatal("Need to supply a bucket.  cleanup only applies to S3, a local store has no multipart uploads.")
+		}
+		store, err := s3helper.NewStore(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		uploads, err := store.MultipartUploads(prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		local := map[string]*resume.Upload{}
+		states, err := store.State().Uploads()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, u := range states {
+			if u.Bucket == opts.Bucket && strings.HasPrefix(u.Key, prefix) {
+				local[u.UploadID] = u
+			}
+		}
+
+		cutoff := time.Now().Add(-olderThan)
+		aborted, kept := 0, 0
+		for _, u := range uploads {
+			state, resumable := local[u.UploadID]
+			delete(local, u.UploadID)
+			if resumable && !all {
+				fmt.Printf("keep\t%s\tresumable from %s\n", u.Key, state.File)
+				kept++
+				continue
+			}
+			if u.Initiated.After(cutoff) {
+				fmt.Printf("keep\t%s\tstarted %s\n", u.Key, u.Initiated.Format(time.RFC3339))
+				kept++
+				continue
+			}
+			fmt.Printf("abort\t%s\tstarted %s\n", u.Key, u.Initiated.Format(time.RFC3339))
+			aborted++
+			if dry {
+				continue
+			}
+			if err := store.AbortUpload(u.Key, u.UploadID); err != nil {
+				log.Error(err)
+				continue
+			}
+			if resumable {
+				store.State().Remove(state)
+			}
+		}
+
+		// What is left in the local state has no upload in the bucket.
+		for _, state := range local {
+			fmt.Printf("forget\t%s\tno upload in the bucket\n", state.File)
+			if !dry {
+				store.State().Remove(state)
+			}
+		}
+
+		verb := "aborted"
+		if dry {
+			verb = "would be aborted"
+		}
+		fmt.Printf("%d uploads %s, %d kept, %d stale upload states.\n", aborted, verb, kept, len(local))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().String("prefix", "", "Only clean up uploads under this prefix.")
+	cleanupCmd.Flags().Duration("older-than", 24*time.Hour, "Only abort uploads started longer ago than this.")
+	cleanupCmd.Flags().Bool("all", false, "Also abort uploads this machine could resume.")
+	cleanupCmd.Flags().Bool("dry-run", false, "List what would be cleaned up without changing anything.")
+}
@@ -15,17 +15,20 @@
 package cmd
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	archive "github.com/jemurai/s3s2/archive"
+	audit "github.com/jemurai/s3s2/audit"
 	"github.com/jemurai/s3s2/encrypt"
 	manifest "github.com/jemurai/s3s2/manifest"
 	options "github.com/jemurai/s3s2/options"
-	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
 	utils "github.com/jemurai/s3s2/utils"
 
 	log "github.com/sirupsen/logrus"
@@ -42,69 +45,201 @@ var decryptCmd = &cobra.Command{
 	Long:  `Retrieve files that are stored securely in S3 and decrypt them`,
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
-		opts := buildDecryptOptions()
+		opts := buildDecryptOptions(cmd)
 		checkDecryptOptions(opts)
+		if opts.URL != "" {
+			store, key, err := linkedShare(opts.URL, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			_, failed, err := decryptShare(store, key, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			timing(start, "Elasped time: %f")
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		store := openStorage(opts)
 
 		if strings.HasSuffix(opts.File, "manifest.json") {
-			log.Debugf("manifest file: %s, %s", opts.Destination, opts.File)
-			fn, err := s3helper.DownloadFile(opts.Destination, opts.File, opts)
+			_, failed, err := decryptShare(store, opts.File, opts)
 			if err != nil {
-				log.Error(err)
+				log.Fatal(err)
 			}
-
-			m := manifest.ReadManifest(fn)
-			var wg sync.WaitGroup
-			for i := 0; i < len(m.Files); i++ {
-				if !strings.HasSuffix(m.Files[i].Name, "manifest.json") {
-					wg.Add(1)
-					f := filepath.Clean(m.Folder + "/" + m.Files[i].Name + ".zip.gpg")
-					go func(f string, opts options.Options) {
-						defer wg.Done()
-						decryptFile(f, opts)
-					}(f, opts)
-				}
+			if failed > 0 {
+				os.Exit(1)
 			}
-			wg.Wait()
-			utils.CleanupDirectory(opts.Destination + m.Folder)
 		} else {
-			decryptFile(opts.File, opts)
+			fn, err := fetchFile(store, opts.File, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := openFile(fn, opts.File, opts); err != nil {
+				log.Fatal(err)
+			}
+			recordAudit(audit.Entry{
+				Action:  audit.Decrypt,
+				ShareID: filepath.Dir(opts.File),
+				Outcome: audit.Success,
+				Detail:  opts.File,
+			}, opts)
 		}
 		timing(start, "Elasped time: %f")
 	},
 }
 
-func decryptFile(file string, options options.Options) {
+// decryptShare downloads and decrypts every file of the share with the
+// manifest at key into the destination.  It returns the manifest and how
+// many files failed, or an error if the share could not be trusted.
+func decryptShare(store storage.Storage, key string, opts options.Options) (manifest.Manifest, int, error) {
+	log.Debugf("manifest file: %s, %s", opts.Destination, key)
+	fn, err := downloadFile(store, opts.Destination, key)
+	if err != nil {
+		return manifest.Manifest{}, 0, err
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return manifest.Manifest{}, 0, err
+	}
+	m, err := manifest.ParseManifest(data)
+	if err != nil {
+		return m, 0, fmt.Errorf("unable to read the manifest %s, %v", key, err)
+	}
+
+	showLabels(m)
+	if err := checkPolicy(opts.Policy, m.AllLabels(), manifestProtection(m)); err != nil {
+		return m, 0, err
+	}
+	if opts.SenderPubKey != "" {
+		if err := checkManifest(m, opts.SenderPubKey); err != nil {
+			recordAudit(audit.Entry{
+				Action:         audit.Decrypt,
+				ShareID:        m.Folder,
+				ManifestDigest: manifest.Digest(m),
+				Outcome:        audit.Failure,
+				Detail:         err.Error(),
+			}, opts)
+			return m, 0, err
+		}
+		log.Infof("Manifest signed by %s verified.", m.Signer)
+	}
+	if !m.Complete() {
+		log.Warnf("The share is incomplete, %d files were never uploaded and are skipped.  The sender can finish it with s3s2 share --resume %s.", len(m.Pending), m.Folder)
+	}
+	pending := map[string]bool{}
+	for _, name := range m.Pending {
+		pending[name] = true
+	}
+	folders := map[string]bool{m.Folder: true}
+	var keys []string
+	var files []manifest.FileDescription
+	for i := 0; i < len(m.Files); i++ {
+		if !strings.HasSuffix(m.Files[i].Name, "manifest.json") && !pending[m.Files[i].Name] {
+			folders[m.ObjectFolder(m.Files[i])] = true
+			keys = append(keys, m.ObjectKey(m.Files[i]))
+			files = append(files, m.Files[i])
+		}
+	}
+	// Downloads are IO bound and decrypting is CPU bound, so
+	// each gets its own workers.
+	downloaded := make([]string, len(keys))
+	errs := runFiles(opts, len(keys),
+		ioStage(opts, func(i int) error {
+			return retryPolicy(opts).Do("Download of "+keys[i], func() error {
+				var err error
+				downloaded[i], err = fetchFile(store, keys[i], opts)
+				if err != nil {
+					return err
+				}
+				return checkDownload(downloaded[i], files[i])
+			})
+		}),
+		cpuStage(opts, func(i int) error {
+			return openFile(downloaded[i], keys[i], opts)
+		}),
+	)
+	for folder := range folders {
+		if folder == "" {
+			continue
+		}
+		dir, err := inDirectory(opts.Destination, folder)
+		if err != nil {
+			log.Warnf("Not cleaning up the share folder, %v", err)
+			continue
+		}
+		utils.CleanupDirectory(dir)
+	}
+	received := checkReceived(files, errs, opts)
+	failed := summarize(keys, errs)
+	if !opts.NoAck {
+		acknowledge(store, path.Dir(key), m, received, opts)
+	}
+	outcome := audit.Success
+	if failed > 0 || !m.Complete() {
+		outcome = audit.Failure
+	}
+	recordAudit(audit.Entry{
+		Action:         audit.Decrypt,
+		ShareID:        m.Folder,
+		ManifestDigest: manifest.Digest(m),
+		Outcome:        outcome,
+		Detail:         fmt.Sprintf("%d of %d files decrypted", len(keys)-failed, len(m.Files)),
+	}, opts)
+	return m, failed, nil
+}
+
+// fetchFile downloads an object from the share.
+func fetchFile(store storage.Storage, file string, options options.Options) (string, error) {
 	log.Debugf("Processing %s", file)
 	start := time.Now()
 
-	fn, err := s3helper.DownloadFile(options.Destination, file, options)
+	var fn string
+	err := inWindow(options, func() error {
+		var err error
+		fn, err = downloadFile(store, options.Destination, file)
+		return err
+	})
 	if err != nil {
-		log.Fatal(err)
+		return fn, err
 	}
 	stat, _ := os.Stat(fn)
 	log.Debugf("Stat of file: %v", stat.Size())
 
-	downloadTime := timing(start, "\tDownload time (sec): %f")
+	timing(start, "\tDownload time (sec): %f")
+	return fn, nil
+}
 
-	encryptTime := time.Now()
+// openFile decrypts, if we have a key, and uncompresses a downloaded file.
+func openFile(fn string, file string, options options.Options) error {
+	start := time.Now()
+	encryptTime := start
 	if options.PrivKey != "" && strings.HasSuffix(file, ".gpg") {
 		log.Debugf("Would be decrypting here... %s", fn)
-		encrypt.Decrypt(fn, options.PubKey, options.PrivKey)
+		if err := encrypt.Decrypt(fn, options.PubKey, options.PrivKey); err != nil {
+			return err
+		}
 		fn = strings.TrimSuffix(fn, ".gpg")
-		encryptTime = timing(downloadTime, "\tDecrypt time (sec): %f")
+		encryptTime = timing(start, "\tDecrypt time (sec): %f")
 	}
 
 	log.Debugf("\tDecompressing file: %s", fn)
-	fn = archive.UnZipFile(fn, options.Destination)
+	fn, err := archive.UnZipFile(fn, options.Destination)
+	if err != nil {
+		return err
+	}
 	// utils.CleanupFile(options.Directory)
 	// utils.CleanupFile(fn + ".gpg")
 
 	timing(encryptTime, "\tDecompress time (sec): %f")
 	timing(start, "Total time: %f")
 	log.Debugf("\tProcessed %s", fn)
+	return nil
 }
 
-func buildDecryptOptions() options.Options {
+func buildDecryptOptions(cmd *cobra.Command) options.Options {
 	bucket := viper.GetString("bucket")
 	file := viper.GetString("file")
 	destination := viper.GetString("destination")
@@ -112,18 +247,31 @@ func buildDecryptOptions() options.Options {
 		destination = destination + "/"
 	}
 	region := viper.GetString("region")
+	localStore := viper.GetString("local-store")
 	privKey := viper.GetString("my-private-key")
 	pubKey := viper.GetString("my-public-key")
+	senderPubKey := flagOrConfig(cmd, "sender-public-key")
+	link, _ := cmd.Flags().GetString("url")
+	noAck, _ := cmd.Flags().GetBool("no-ack")
 
 	options := options.Options{
 		Bucket:      bucket,
 		File:        file,
 		Destination: destination,
 		Region:      region,
+		LocalStore:  localStore,
 		PrivKey:     privKey,
 		PubKey:      pubKey,
+		URL:         link,
+		NoAck:       noAck,
+
+		SenderPubKey: senderPubKey,
+		Policy:       policyRules(),
 	}
 
+	options = withConnection(options)
+	options = withWorkers(options)
+
 	debug := viper.GetBool("debug")
 	if debug != true {
 		log.Debug("Setting Debug in Decrypt")
@@ -135,30 +283,38 @@ func buildDecryptOptions() options.Options {
 }
 
 func checkDecryptOptions(options options.Options) {
-	if options.File == "" {
+	if options.URL != "" {
+		if options.Destination == "" {
+			log.Warn("Need to supply a destination for the files to decrypt.  Should be a local path.")
+			log.Panic("Insufficient information to perform decryption.")
+		}
+	} else if options.File == "" {
 		log.Warn("Need to supply a file to decrypt.  Should be the file path within the dbucket but not including the dbucket.")
 		log.Panic("Insufficient information to perform decryption.")
-	} else if options.Bucket == "" {
+	} else if options.Bucket == "" && options.LocalStore == "" {
 		log.Warn("Need to supply a bucket.")
 		log.Panic("Insufficient information to perform decryption.")
 	} else if options.Destination == "" {
 		log.Warn("Need to supply a destination for the files to decrypt.  Should be a local path.")
 		log.Panic("Insufficient information to perform decryption.")
-	} else if options.Region == "" {
+	} else if options.Region == "" && options.LocalStore == "" && options.Endpoint == "" {
 		log.Warn("Need to supply a region for the S3 bucket.")
 		log.Panic("Insufficient information to perform decryption.")
 	}
+	checkTransferOptions(options)
 }
 
 func init() {
 	rootCmd.AddCommand(decryptCmd)
 
 	decryptCmd.PersistentFlags().String("file", "", "The path to the file to decrypt.  Can be manifest or single file.")
-	decryptCmd.MarkFlagRequired("file")
 	decryptCmd.PersistentFlags().String("destination", "", "The destination directory to decrypt and unzip.")
 	decryptCmd.MarkFlagRequired("destination")
 	decryptCmd.PersistentFlags().String("my-private-key", "", "The receiver's private key.  A local file path.")
 	decryptCmd.PersistentFlags().String("my-public-key", "", "The receiver's public key.  A local file path.")
+	decryptCmd.PersistentFlags().String("url", "", "A presigned link to the manifest of a share, from s3s2 presign.  No bucket or AWS credentials are needed.")
+	decryptCmd.PersistentFlags().String("sender-public-key", "", "The sender's public key.  If given, the manifest signature must verify.")
+	decryptCmd.PersistentFlags().Bool("no-ack", false, "Do not write a signed acknowledgement of the files received back into the share.")
 
 	viper.BindPFlag("file", decryptCmd.PersistentFlags().Lookup("file"))
 	viper.BindPFlag("destination", decryptCmd.PersistentFlags().Lookup("destination"))
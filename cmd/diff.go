@@ -0,0 +1,119 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Compare two shares or a share and a local directory",
+	Long: `Compare two shares or a share and a local directory.
+
+Each argument can be a local manifest file, a local directory or the
+key of a manifest in the bucket.  Files are reported as added, removed,
+modified or renamed.  Files are compared by hash when both sides were
+hashed and by size and modification time otherwise.  Like diff, the
+command exits with status 1 when differences are found.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		hash, _ := cmd.Flags().GetBool("hash")
+		opts := options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+			Hash:       hash,
+		}
+
+		from, err := loadManifest(args[0], opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		to, err := loadManifest(args[1], opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d := manifest.Diff(from, to)
+
+		if format == "json" {
+			data, _ := json.MarshalIndent(d, "", " ")
+			fmt.Println(string(data))
+		} else {
+			printDiff(d)
+		}
+		if d.Changed() {
+			os.Exit(1)
+		}
+	},
+}
+
+// loadManifest reads a manifest from a local file or a local directory
+// or, failing those, from the bucket.
+func loadManifest(location string, opts options.Options) (manifest.Manifest, error) {
+	if stat, err := os.Stat(location); err == nil {
+		if stat.IsDir() {
+			opts.Directory = location
+			files, _, err := manifest.ScanDirectory(opts)
+			return manifest.Manifest{Folder: location, Files: files}, err
+		}
+		return manifest.ReadManifest(location), nil
+	}
+
+	if !hasStorage(opts) {
+		return manifest.Manifest{}, fmt.Errorf("%s is not a local file and no bucket and region were given", location)
+	}
+	data, err := storage.ReadObject(openStorage(opts), location)
+	if err != nil {
+		return manifest.Manifest{}, fmt.Errorf("unable to read manifest %s, %v", location, err)
+	}
+	return manifest.ParseManifest(data)
+}
+
+func printDiff(d manifest.Difference) {
+	for _, f := range d.Added {
+		fmt.Printf("A\t%s\n", manifest.CleanName(f.Name))
+	}
+	for _, f := range d.Removed {
+		fmt.Printf("D\t%s\n", manifest.CleanName(f.Name))
+	}
+	for _, f := range d.Modified {
+		fmt.Printf("M\t%s\n", manifest.CleanName(f.Name))
+	}
+	for _, r := range d.Renamed {
+		fmt.Printf("R\t%s -> %s\n", manifest.CleanName(r.From.Name), manifest.CleanName(r.To.Name))
+	}
+	fmt.Printf("%d added, %d removed, %d modified, %d renamed, %d unchanged.\n",
+		len(d.Added), len(d.Removed), len(d.Modified), len(d.Renamed), len(d.Unchanged))
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().String("format", "text", "The output format: text or json.")
+	diffCmd.Flags().Bool("hash", false, "Hash the files of a local directory so it can be compared by content.")
+}
@@ -0,0 +1,87 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	options "github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that a bucket is set up safely for sharing",
+	Long: `Check that a bucket is set up safely for sharing.
+
+doctor reads the bucket's Block Public Access settings, default
+encryption, versioning, policy and ACL, and checks that the KMS key
+in --awskey exists, is enabled and can be used by the caller.  It
+fails if the bucket could be made public, objects are not encrypted
+by default, plain HTTP is allowed or the key is not usable, warns
+about things that are worth fixing, and exits with an error on any
+failure.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := withConnection(options.Options{
+			Bucket: viper.GetString("bucket"),
+			Region: viper.GetString("region"),
+			AwsKey: flagOrConfig(cmd, "awskey"),
+		})
+		if opts.Bucket == "" || (opts.Region == "" && opts.Endpoint == "") {
+			log.Fatal("Need to supply a bucket and region.")
+		}
+		r := &report{}
+		checkPosture(r, opts, false)
+		r.summary()
+		if r.failures > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// checkPosture reports on the security configuration of the bucket.
+// When strict, a setting that could not be read fails rather than warns.
+func checkPosture(r *report, opts options.Options, strict bool) {
+	store, err := s3helper.NewStore(opts)
+	if err != nil {
+		r.fail("Unable to reach the bucket, %v", err)
+		return
+	}
+	for _, f := range s3helper.CheckPosture(store.BucketConfig(opts.AwsKey)) {
+		switch f.Status {
+		case s3helper.Pass:
+			r.pass("%s: %s.", f.Check, f.Detail)
+		case s3helper.Warn:
+			if strict && f.Unchecked {
+				r.fail("%s: %s.", f.Check, f.Detail)
+			} else {
+				r.warn("%s: %s.", f.Check, f.Detail)
+			}
+		default:
+			r.fail("%s: %s.", f.Check, f.Detail)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().String("awskey", "", "The KMS key shares are encrypted with.")
+}
@@ -0,0 +1,157 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	encrypt "github.com/jemurai/s3s2/encrypt"
+	options "github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	ticket "github.com/jemurai/s3s2/ticket"
+)
+
+// grantCmd represents the grant command
+var grantCmd = &cobra.Command{
+	Use:   "grant",
+	Short: "Let a partner upload one share without AWS credentials",
+	Long: `Let a partner upload one share without AWS credentials.
+
+grant writes a ticket of presigned links to upload up to --files files
+and a manifest into a new folder of the bucket, signed with your key.
+Send it to the partner, who runs:
+
+  s3s2 share --ticket <ticket> --receiver-public-key <your public key> ...
+
+The files must be encrypted for your key.  Anyone with the ticket can
+upload into the folder until it expires, so send it over a channel
+you trust.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, _ := cmd.Flags().GetInt("files")
+		expires, _ := cmd.Flags().GetDuration("expires")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		output, _ := cmd.Flags().GetString("output")
+		pubKey := flagOrConfig(cmd, "my-public-key")
+		privKey := flagOrConfig(cmd, "my-private-key")
+
+		if files <= 0 {
+			log.Fatal("Need to allow at least one file with --files.")
+		}
+		if pubKey == "" || privKey == "" {
+			log.Fatal("Need --my-public-key and --my-private-key to sign the ticket.")
+		}
+		opts := withConnection(options.Options{
+			Bucket: viper.GetString("bucket"),
+			Region: viper.GetString("region"),
+			AwsKey: flagOrConfig(cmd, "awskey"),
+		})
+		if opts.Bucket == "" || (opts.Region == "" && opts.Endpoint == "") {
+			log.Fatal("Need to supply a bucket and region.")
+		}
+		store, err := s3helper.NewStore(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		t, err := grantTicket(store, opts, prefix, files, expires)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if t.Recipient, err = encrypt.Fingerprint(pubKey); err != nil {
+			log.Fatal(err)
+		}
+		if err := t.Sign(pubKey, privKey); err != nil {
+			log.Fatal(err)
+		}
+		if err := t.Write(output); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Ticket for %d files into %s, valid until %s, written to %s.\n",
+			files, t.Folder, t.Expires.Local().Format(time.RFC1123), output)
+	},
+}
+
+// grantTicket presigns the uploads for a new share folder.
+func grantTicket(store *s3helper.Store, opts options.Options, prefix string, files int, expires time.Duration) (ticket.Ticket, error) {
+	id, _ := uuid.NewV4()
+	now := time.Now().UTC()
+	t := ticket.Ticket{
+		Folder:  prefix + "_s3s2_" + id.String(),
+		Bucket:  opts.Bucket,
+		AwsKey:  opts.AwsKey,
+		SSE:     opts.SSE,
+		Issued:  now,
+		Expires: now.Add(expires),
+	}
+	presign := func(key string) (ticket.Slot, error) {
+		link, headers, err := store.PresignPut(key, expires)
+		return ticket.Slot{Key: key, URL: link, Headers: headers}, err
+	}
+	var err error
+	if t.Manifest, err = presign(manifestKey(t.Folder)); err != nil {
+		return t, err
+	}
+	for n := 1; n <= files; n++ {
+		slot, err := presign(ticket.SlotKey(t.Folder, n))
+		if err != nil {
+			return t, err
+		}
+		t.Files = append(t.Files, slot)
+	}
+	return t, nil
+}
+
+// readTicket checks a ticket a receiver sent and sets the share up to
+// go through it: into its folder, encrypted at the bucket the way the
+// receiver asked.
+func readTicket(opts *options.Options) ticket.Ticket {
+	t, err := ticket.Read(opts.Ticket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.PubKey == "" {
+		log.Fatal("A share through a ticket must be encrypted for the receiver, give --receiver-public-key.")
+	}
+	if err := t.Verify(opts.PubKey, time.Now()); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Resume != "" || opts.Presign != 0 {
+		log.Fatal("--resume and --presign need access to the bucket, they can not be used with a ticket.")
+	}
+	opts.AwsKey, opts.SSE = t.AwsKey, t.SSE
+	if s3helper.ServerSideEncryption(*opts) == s3helper.SSECustomer {
+		log.Fatalf("A share through a ticket can not be encrypted with %s, the links do not carry a key.", s3helper.SSECustomer)
+	}
+	return t
+}
+
+func init() {
+	rootCmd.AddCommand(grantCmd)
+
+	grantCmd.Flags().Int("files", 0, "The most files the partner may upload.")
+	grantCmd.Flags().Duration("expires", 24*time.Hour, "How long the ticket works, at most 168h (7 days).")
+	grantCmd.Flags().String("prefix", "", "A prefix for the share folder.")
+	grantCmd.Flags().String("output", "s3s2_ticket.json", "The file to write the ticket to.")
+	grantCmd.Flags().String("awskey", "", "The KMS key the uploads are encrypted with at the bucket.")
+	grantCmd.Flags().String("my-public-key", "", "The receiver's public key.  Files are encrypted for it.")
+	grantCmd.Flags().String("my-private-key", "", "The receiver's private key, to sign the ticket.")
+}
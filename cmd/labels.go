@@ -0,0 +1,130 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	policy "github.com/jemurai/s3s2/policy"
+	s3helper "github.com/jemurai/s3s2/s3"
+)
+
+// policyRules reads the classification policy from the config file.
+func policyRules() []policy.Rule {
+	var rules []policy.Rule
+	if err := viper.UnmarshalKey("policy", &rules); err != nil {
+		log.Fatalf("Unable to read the policy from the config file, %v", err)
+	}
+	return rules
+}
+
+// applyLabels labels the share and its files from the options and
+// the label file at the root of the directory being shared.
+func applyLabels(m *manifest.Manifest, options options.Options) error {
+	var fileLabels []policy.FileLabel
+	for _, s := range options.FileLabels {
+		fl, err := policy.ParseFileLabel(s)
+		if err != nil {
+			return err
+		}
+		fileLabels = append(fileLabels, fl)
+	}
+	sidecar, err := policy.ReadLabelFile(filepath.Join(options.Directory, policy.LabelFileName))
+	if err != nil {
+		return err
+	}
+	fileLabels = append(fileLabels, sidecar...)
+
+	m.Labels = policy.Union(options.Labels)
+	for i := range m.Files {
+		m.Files[i].Labels = policy.LabelsFor(manifest.CleanName(m.Files[i].Name), fileLabels)
+	}
+	return nil
+}
+
+// shareProtection describes how a share we are about to send is protected.
+func shareProtection(options options.Options) policy.Protection {
+	return policy.Protection{
+		KMS:    s3helper.ServerSideEncryption(options) == "aws:kms",
+		GPG:    options.PubKey != "",
+		Signed: options.SenderPrivKey != "",
+		Hashed: options.Hash,
+		Prefix: options.Prefix,
+	}
+}
+
+// manifestProtection describes how a share we received was protected.
+func manifestProtection(m manifest.Manifest) policy.Protection {
+	prefix := m.Folder
+	if i := strings.LastIndex(m.Folder, "_s3s2_"); i >= 0 {
+		prefix = m.Folder[:i]
+	}
+	return policy.Protection{
+		KMS:    m.AwsKey != "",
+		GPG:    len(m.Recipients) > 0,
+		Signed: m.Signature != "",
+		Hashed: m.Root != "",
+		Prefix: prefix,
+	}
+}
+
+// enforcePolicy stops if the labels on a share break any policy rule.
+func enforcePolicy(rules []policy.Rule, labels []string, protection policy.Protection) {
+	if err := checkPolicy(rules, labels, protection); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkPolicy logs each broken policy rule and returns an error if any were.
+func checkPolicy(rules []policy.Rule, labels []string, protection policy.Protection) error {
+	violations := policy.Check(rules, labels, protection)
+	for _, v := range violations {
+		log.Error(v)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("share labeled %s does not meet the classification policy", strings.Join(labels, ", "))
+	}
+	return nil
+}
+
+// labelTags are the object tags for the labels on a file.
+func labelTags(m manifest.Manifest, f manifest.FileDescription) map[string]string {
+	labels := policy.Union(m.Labels, f.Labels)
+	if len(labels) == 0 {
+		return nil
+	}
+	return map[string]string{tagClassification: strings.Join(labels, "+")}
+}
+
+// showLabels tells the receiver how a share is classified.
+func showLabels(m manifest.Manifest) {
+	labels := m.AllLabels()
+	if len(labels) == 0 {
+		return
+	}
+	fmt.Printf("Share %s is labeled: %s\n", m.Folder, strings.Join(labels, ", "))
+	for _, f := range m.Files {
+		if len(f.Labels) > 0 {
+			fmt.Printf("\t%s: %s\n", manifest.CleanName(f.Name), strings.Join(f.Labels, ", "))
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shares in a bucket",
+	Long: `List the shares in a bucket.
+
+Every share has a manifest at <share id>/s3s2_manifest.json.  list
+finds them under --prefix and shows who shared what and when, how
+many files and bytes, and whether every file was uploaded.  Pass the
+manifest to decrypt with --file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix, _ := cmd.Flags().GetString("prefix")
+		org, _ := cmd.Flags().GetString("org")
+		after, _ := cmd.Flags().GetString("after")
+		before, _ := cmd.Flags().GetString("before")
+		by, _ := cmd.Flags().GetString("sort")
+		reverse, _ := cmd.Flags().GetBool("reverse")
+		format, _ := cmd.Flags().GetString("format")
+		withTags, _ := cmd.Flags().GetBool("tags")
+
+		filter := manifest.Filter{Organization: org}
+		var err error
+		if filter.After, err = parseDate(after); err != nil {
+			log.Fatal(err)
+		}
+		if filter.Before, err = parseDate(before); err != nil {
+			log.Fatal(err)
+		}
+
+		opts := withWorkers(options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+		})
+		if !hasStorage(opts) {
+			log.Fatal("Need to supply a bucket and region, or a local store.")
+		}
+		if withTags && opts.LocalStore != "" {
+			log.Warn("A local store keeps no tags, --tags is ignored.")
+			withTags = false
+		}
+		summaries, err := listShares(openStorage(opts), prefix, withTags, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var shown []manifest.Summary
+		for _, s := range summaries {
+			if filter.Matches(s) {
+				shown = append(shown, s)
+			}
+		}
+		if err := manifest.SortSummaries(shown, by, reverse); err != nil {
+			log.Fatal(err)
+		}
+
+		switch format {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if shown == nil {
+				shown = []manifest.Summary{}
+			}
+			if err := enc.Encode(shown); err != nil {
+				log.Fatal(err)
+			}
+		case "table", "":
+			printShares(shown, withTags)
+		default:
+			log.Fatalf("Unknown format %q, use table or json.", format)
+		}
+	},
+}
+
+// listShares reads the manifest of every share under the prefix, and
+// the tags on it if asked.
+func listShares(store storage.Storage, prefix string, withTags bool, opts options.Options) ([]manifest.Summary, error) {
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, o := range objects {
+		if isManifestKey(o.Key) {
+			keys = append(keys, o.Key)
+		}
+	}
+
+	summaries := make([]manifest.Summary, len(keys))
+	errs := runFiles(opts, len(keys), ioStage(opts, func(i int) error {
+		return retryPolicy(opts).Do("Reading "+keys[i], func() error {
+			data, err := storage.ReadObject(store, keys[i])
+			if err != nil {
+				return err
+			}
+			m, err := manifest.ParseManifest(data)
+			if err != nil {
+				return err
+			}
+			summaries[i] = manifest.Summarize(keys[i], m)
+			if withTags {
+				details, err := storage.Describe(store, keys[i])
+				if err != nil {
+					return err
+				}
+				summaries[i].Tags = details.Tags
+			}
+			return nil
+		})
+	}))
+
+	var found []manifest.Summary
+	for i, err := range errs {
+		if err != nil {
+			log.Warnf("Skipping %s, %v", keys[i], err)
+			continue
+		}
+		found = append(found, summaries[i])
+	}
+	return found, nil
+}
+
+// isManifestKey reports whether an object is the manifest of a share.
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, "/"+manifest.FileName)
+}
+
+func printShares(summaries []manifest.Summary, withTags bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "SHARE ID\tORG\tSENDER\tSHARED\tFILES\tSIZE\tSTATUS"
+	if withTags {
+		header += "\tTAGS"
+	}
+	fmt.Fprintln(w, header)
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s", s.ShareID, s.Organization, s.Sender,
+			s.Shared.Local().Format("2006-01-02 15:04"), s.FileCount, humanSize(s.TotalSize), s.Status())
+		if withTags {
+			fmt.Fprintf(w, "\t%s", formatTags(s.Tags))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	fmt.Printf("%d shares.\n", len(summaries))
+}
+
+// formatTags writes tags as name=value pairs, sorted by name.
+func formatTags(tags map[string]string) string {
+	var pairs []string
+	for name, value := range tags {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseDate reads a date, or a date and time, from a flag.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to read the date %q, use YYYY-MM-DD or RFC 3339", value)
+}
+
+// humanSize writes a byte count in KiB, MiB and so on.
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().String("prefix", "", "Only list shares under this prefix.")
+	listCmd.Flags().String("org", "", "Only list shares from this organization.")
+	listCmd.Flags().String("after", "", "Only list shares made on or after this date (YYYY-MM-DD or RFC 3339).")
+	listCmd.Flags().String("before", "", "Only list shares made before this date (YYYY-MM-DD or RFC 3339).")
+	listCmd.Flags().String("sort", "time", "Sort by time, org, sender, files, size or id.")
+	listCmd.Flags().Bool("reverse", false, "Reverse the sort, such as newest first.")
+	listCmd.Flags().String("format", "table", "The output format: table or json.")
+	listCmd.Flags().Bool("tags", false, "Also read the tags on each share's manifest, such as its org, classification and expiry.")
+}
@@ -0,0 +1,156 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// presignCmd represents the presign command
+var presignCmd = &cobra.Command{
+	Use:   "presign",
+	Short: "Make a link to download a share without AWS credentials",
+	Long: `Make a link to download a share without AWS credentials.
+
+presign adds a presigned GET link for every file of the share to its
+manifest, then prints a presigned link to the manifest.  Anyone with
+that link can download the share, still encrypted, until the links
+expire, so send it over a channel you trust.  A signed manifest is
+signed again, with --sender-public-key and --sender-private-key.  The
+receiver runs:
+
+  s3s2 decrypt --url <link> --destination <dir> --my-private-key <key>`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		expires, _ := cmd.Flags().GetDuration("expires")
+		if file == "" {
+			log.Fatal("Need to supply a manifest with --file.")
+		}
+		opts := withConnection(options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+
+			SenderPubKey:  flagOrConfig(cmd, "sender-public-key"),
+			SenderPrivKey: flagOrConfig(cmd, "sender-private-key"),
+		})
+		if !hasStorage(opts) {
+			log.Fatal("Need to supply a bucket and region.")
+		}
+		link, err := presignShare(openStorage(opts), file, expires, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(link)
+	},
+}
+
+// presignShare puts presigned links to the files of a share in its
+// manifest and returns a presigned link to the manifest.  A signed
+// manifest is signed again with the sender's keys.
+func presignShare(store storage.Storage, key string, expires time.Duration, opts options.Options) (string, error) {
+	data, err := storage.ReadObject(store, key)
+	if err != nil {
+		return "", fmt.Errorf("unable to read manifest %s, %v", key, err)
+	}
+	m, err := manifest.ParseManifest(data)
+	if err != nil {
+		return "", err
+	}
+	if m.Signature != "" && opts.SenderPrivKey == "" {
+		return "", fmt.Errorf("the manifest of %s is signed by %s, give --sender-public-key and --sender-private-key to sign it again with the links", m.Folder, m.Signer)
+	}
+	if !m.Complete() {
+		log.Warnf("The share is incomplete, there are no links for the %d files that were never uploaded.", len(m.Pending))
+	}
+
+	pending := map[string]bool{}
+	for _, name := range m.Pending {
+		pending[name] = true
+	}
+	m.Links = map[string]string{}
+	for _, f := range m.Files {
+		if pending[f.Name] || strings.HasSuffix(f.Name, "manifest.json") {
+			continue
+		}
+		objectKey := m.ObjectKey(f)
+		if m.Links[objectKey], err = storage.Presign(store, objectKey, expires); err != nil {
+			return "", err
+		}
+	}
+	expiry := time.Now().Add(expires).UTC()
+	m.LinksExpire = &expiry
+	if m.Signature != "" {
+		if err := signManifest(&m, opts.SenderPubKey, opts.SenderPrivKey); err != nil {
+			return "", err
+		}
+	}
+
+	data, _ = json.MarshalIndent(m, "", " ")
+	if _, err := storage.WriteObject(store, key, data, manifestOptions(m)); err != nil {
+		return "", err
+	}
+	return storage.Presign(store, key, expires)
+}
+
+// linkedShare reads a manifest through a presigned link and returns the
+// storage to read the rest of the share through, and the manifest key.
+func linkedShare(link string, opts options.Options) (storage.Storage, string, error) {
+	client, err := s3helper.HTTPClient(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := storage.ReadObject(storage.NewHTTP(client, map[string]string{manifest.FileName: link}), manifest.FileName)
+	if err != nil {
+		return nil, "", err
+	}
+	m, err := manifest.ParseManifest(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read the manifest, %v", err)
+	}
+	if len(m.Links) == 0 {
+		return nil, "", fmt.Errorf("the manifest of %s has no links, make them with s3s2 presign", m.Folder)
+	}
+	if m.LinksExpire != nil && time.Now().After(*m.LinksExpire) {
+		return nil, "", fmt.Errorf("the links to %s expired at %s", m.Folder, m.LinksExpire.Local().Format(time.RFC1123))
+	}
+	key := manifestKey(m.Folder)
+	links := map[string]string{key: link}
+	for k, v := range m.Links {
+		links[k] = v
+	}
+	return storage.NewHTTP(client, links), key, nil
+}
+
+func init() {
+	rootCmd.AddCommand(presignCmd)
+
+	presignCmd.Flags().String("file", "", "The manifest of the share, its key in the bucket.")
+	presignCmd.Flags().Duration("expires", 72*time.Hour, "How long the links work, at most 168h (7 days).")
+	presignCmd.Flags().String("sender-public-key", "", "The sender's public key, to sign a signed manifest again.  A local file path.")
+	presignCmd.Flags().String("sender-private-key", "", "The sender's private key, to sign a signed manifest again.  A local file path.")
+}
@@ -0,0 +1,332 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	ack "github.com/jemurai/s3s2/ack"
+	audit "github.com/jemurai/s3s2/audit"
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete shares from the bucket",
+	Long: `Delete shares from the bucket.
+
+purge deletes every object in the folders of the shares picked with
+--share, --older-than, --expired, --org, --acknowledged or --all.  A
+share has to match all of the ones given.  It lists what it would
+delete and asks before deleting anything, unless --yes is given.  A
+share whose files a later incremental share anywhere in the bucket
+still uses is kept, unless that share is purged too.
+
+--acknowledged needs the receiver's public key, and only picks shares
+with acknowledgements signed by it that show every file delivered.
+Acknowledgements of an earlier version of the manifest do not count.
+
+In a versioned bucket a delete only hides an object behind a delete
+marker and the data is kept until a lifecycle rule expires it.  Use
+--permanent to delete every version and delete marker instead.  Each
+purged share is recorded in the audit ledger.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		shares, _ := cmd.Flags().GetStringSlice("share")
+		org, _ := cmd.Flags().GetString("org")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		expired, _ := cmd.Flags().GetBool("expired")
+		acknowledged, _ := cmd.Flags().GetBool("acknowledged")
+		all, _ := cmd.Flags().GetBool("all")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		permanent, _ := cmd.Flags().GetBool("permanent")
+		dry, _ := cmd.Flags().GetBool("dry-run")
+		yes, _ := cmd.Flags().GetBool("yes")
+		receiverKey := flagOrConfig(cmd, "receiver-public-key")
+
+		if len(shares) == 0 && olderThan <= 0 && !expired && org == "" && !acknowledged && !all {
+			log.Fatal("Say which shares to purge with --share, --older-than, --expired, --org, --acknowledged or --all.")
+		}
+		if acknowledged && receiverKey == "" {
+			log.Fatal("Need to supply --receiver-public-key to check the acknowledgements.")
+		}
+		if !acknowledged {
+			receiverKey = ""
+		}
+		opts := withWorkers(withConnection(options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+		}))
+		if !hasStorage(opts) {
+			log.Fatal("Need to supply a bucket and region, or a local store.")
+		}
+		store := openStorage(opts)
+		summaries, err := listShares(store, prefix, false, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Shares outside the prefix can still use the files of the ones
+		// under it.
+		everything := summaries
+		if prefix != "" {
+			if everything, err = listShares(store, "", false, opts); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		now := time.Now()
+		filter := manifest.Filter{Organization: org}
+		if olderThan > 0 {
+			filter.Before = now.Add(-olderThan)
+		}
+		if expired {
+			filter.ExpiredBy = now
+		}
+		wanted := map[string]bool{}
+		for _, id := range shares {
+			wanted[path.Dir(manifestKey(id))] = true
+		}
+		found := map[string]bool{}
+		var picked []manifest.Summary
+		for _, s := range summaries {
+			found[s.ShareID] = true
+			if (len(wanted) == 0 || wanted[s.ShareID]) && filter.Matches(s) {
+				picked = append(picked, s)
+			}
+		}
+		for id := range wanted {
+			if !found[id] {
+				log.Warnf("There is no share %s.", id)
+			}
+		}
+
+		plans, err := planPurge(store, everything, picked, receiverKey, permanent)
+		if err != nil {
+			log.Fatal(err)
+		}
+		objects, count := 0, 0
+		for _, p := range plans {
+			if p.keep != "" {
+				fmt.Printf("keep\t%s\t%s\n", p.share.ShareID, p.keep)
+				continue
+			}
+			fmt.Printf("purge\t%s\t%s\t%s\t%d objects, %s\n", p.share.ShareID, p.share.Organization,
+				p.share.Shared.Local().Format("2006-01-02 15:04"), len(p.objects), humanSize(p.size))
+			objects += len(p.objects)
+			count++
+		}
+		if dry || count == 0 {
+			fmt.Printf("%d shares, %d objects would be purged.\n", count, objects)
+			return
+		}
+		if !yes && !confirm(fmt.Sprintf("Delete %d objects in %d shares?", objects, count)) {
+			fmt.Println("Nothing was deleted.")
+			return
+		}
+
+		failed := 0
+		for _, p := range plans {
+			if p.keep == "" && !purgeShare(store, p, opts) {
+				failed++
+			}
+		}
+		fmt.Printf("%d shares purged, %d failed.\n", count-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// purgePlan is what purge does with one share: keep it, for a reason,
+// or delete its objects.
+type purgePlan struct {
+	share   manifest.Summary
+	keep    string
+	objects []s3helper.ObjectVersion
+	size    int64
+}
+
+// planPurge decides which of the picked shares to purge and lists the
+// objects, or with permanent every version, to delete for each.  all is
+// every share in the bucket.  With receiverKey, only shares the receiver
+// acknowledged as delivered are purged.
+func planPurge(store storage.Storage, all []manifest.Summary, picked []manifest.Summary, receiverKey string, permanent bool) ([]purgePlan, error) {
+	bucket, isBucket := store.(*s3helper.Store)
+	if isBucket {
+		versioned, err := bucket.Versioned()
+		if err != nil {
+			return nil, err
+		}
+		if versioned && !permanent {
+			log.Warn("The bucket is versioned, purged objects are only hidden behind delete markers until a lifecycle rule expires them.  Use --permanent to delete every version.")
+		}
+		permanent = permanent && versioned
+	}
+
+	purge := map[string]bool{}
+	plans := make([]purgePlan, len(picked))
+	for i, s := range picked {
+		plans[i].share = s
+		folder := path.Dir(s.Manifest) + "/"
+		listed, err := store.List(folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range listed {
+			plans[i].objects = append(plans[i].objects, s3helper.ObjectVersion{Key: o.Key, Size: o.Size})
+			plans[i].size += o.Size
+		}
+		if receiverKey != "" {
+			status, err := deliveryStatus(store, s, receiverKey)
+			if err != nil {
+				return nil, err
+			}
+			if status != ack.Delivered {
+				plans[i].keep = status
+				continue
+			}
+		}
+		if permanent {
+			if plans[i].objects, err = bucket.Versions(folder); err != nil {
+				return nil, err
+			}
+			plans[i].size = 0
+			for _, v := range plans[i].objects {
+				plans[i].size += v.Size
+			}
+		}
+		purge[s.ShareID] = true
+	}
+	for share, user := range manifest.StillUsed(all, purge) {
+		for i := range plans {
+			if plans[i].share.ShareID == share {
+				plans[i].keep = "files still used by " + user
+			}
+		}
+	}
+	return plans, nil
+}
+
+// deliveryStatus is how much of a share the receiver acknowledged, from
+// the acknowledgements signed with the receiver's key for the manifest
+// as it is now.
+func deliveryStatus(store storage.Storage, s manifest.Summary, receiverKey string) (string, error) {
+	data, err := storage.ReadObject(store, s.Manifest)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the manifest %s, %v", s.Manifest, err)
+	}
+	m, err := manifest.ParseManifest(data)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the manifest %s, %v", s.Manifest, err)
+	}
+	folder := path.Dir(s.Manifest)
+	acks, err := readAcks(store, folder, m, receiverKey)
+	if err != nil {
+		return "", err
+	}
+	digest := manifest.Digest(m)
+	var current []ack.Ack
+	for _, a := range acks {
+		if a.For(folder, digest) {
+			current = append(current, a)
+		}
+	}
+	return ack.Deliver(current, deliveredNames(m)).Status, nil
+}
+
+// purgeShare deletes the objects of a share and records it in the
+// audit ledger.  The manifest goes last, so a purge that fails part way
+// can be run again.
+func purgeShare(store storage.Storage, p purgePlan, opts options.Options) bool {
+	var objects, manifests []s3helper.ObjectVersion
+	for _, o := range p.objects {
+		if o.Key == p.share.Manifest {
+			manifests = append(manifests, o)
+		} else {
+			objects = append(objects, o)
+		}
+	}
+	deleted := 0
+	for _, batch := range [][]s3helper.ObjectVersion{objects, manifests} {
+		names := make([]string, len(batch))
+		errs := runFiles(opts, len(batch), ioStage(opts, func(i int) error {
+			o := batch[i]
+			names[i] = o.Key
+			return retryPolicy(opts).Do("Delete of "+o.Key, func() error {
+				if o.VersionID != "" {
+					return store.(*s3helper.Store).DeleteVersion(o.Key, o.VersionID)
+				}
+				return store.Delete(o.Key)
+			})
+		}))
+		failed := summarize(names, errs)
+		deleted += len(batch) - failed
+		if failed > 0 {
+			break
+		}
+	}
+
+	outcome, what := audit.Success, "objects"
+	if len(p.objects) > 0 && p.objects[0].VersionID != "" {
+		what = "versions, permanently"
+	}
+	if deleted < len(p.objects) {
+		outcome = audit.Failure
+	}
+	recordAudit(audit.Entry{
+		Action:         audit.Purge,
+		ShareID:        p.share.ShareID,
+		ManifestDigest: p.share.Digest,
+		Outcome:        outcome,
+		Detail:         fmt.Sprintf("%d of %d %s deleted", deleted, len(p.objects), what),
+	}, opts)
+	return outcome == audit.Success
+}
+
+// confirm asks a yes or no question on the terminal.
+func confirm(question string) bool {
+	fmt.Printf("%s Type yes to continue: ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+
+	purgeCmd.Flags().StringSlice("share", []string{}, "The id (folder) of a share to purge.  Can be given more than once.")
+	purgeCmd.Flags().String("org", "", "Only purge shares from this organization.")
+	purgeCmd.Flags().Duration("older-than", 0, "Only purge shares made longer ago than this, such as 2160h.")
+	purgeCmd.Flags().Bool("expired", false, "Only purge shares past the expiry set with share --retention.")
+	purgeCmd.Flags().Bool("acknowledged", false, "Only purge shares the receiver has acknowledged as delivered.")
+	purgeCmd.Flags().String("receiver-public-key", "", "The receiver's public key to check the acknowledgements with.  A local file path.")
+	purgeCmd.Flags().Bool("all", false, "Purge every share under --prefix.")
+	purgeCmd.Flags().String("prefix", "", "Only purge shares under this prefix.")
+	purgeCmd.Flags().Bool("permanent", false, "In a versioned bucket, delete every version and delete marker rather than leave delete markers.")
+	purgeCmd.Flags().Bool("dry-run", false, "List what would be purged without deleting anything.")
+	purgeCmd.Flags().Bool("yes", false, "Do not ask before deleting.")
+}
@@ -0,0 +1,78 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	options "github.com/jemurai/s3s2/options"
+	receipt "github.com/jemurai/s3s2/receipt"
+)
+
+// receiptCmd represents the receipt command
+var receiptCmd = &cobra.Command{
+	Use:   "receipt",
+	Short: "Produce a compliance receipt for a share",
+	Long: `Produce a compliance receipt for a share.
+
+The receipt records who sent what, when, to whom and how it was
+protected: the KMS key, the recipient key fingerprints, the object
+keys, ETags and file hashes.  It can be written as text, markdown
+or HTML for people and as JSON for other tools.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		if file == "" {
+			log.Fatal("Need to supply a manifest with --file.")
+		}
+		opts := options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+		}
+
+		m, err := loadManifest(file, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := receipt.New(m).Write(w, format); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(receiptCmd)
+
+	receiptCmd.Flags().String("file", "", "The manifest of the share.  A local file or the key in the bucket.")
+	receiptCmd.Flags().String("format", "text", "The receipt format: text, markdown, html or json.")
+	receiptCmd.Flags().String("output", "", "Write the receipt to this file instead of standard out.")
+}
@@ -0,0 +1,336 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	inbox "github.com/jemurai/s3s2/inbox"
+	manifest "github.com/jemurai/s3s2/manifest"
+	notify "github.com/jemurai/s3s2/notify"
+	options "github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// receiveCmd represents the receive command
+var receiveCmd = &cobra.Command{
+	Use:   "receive",
+	Short: "Decrypt every new share in a bucket",
+	Long: `Decrypt every new share in a bucket.
+
+receive finds the shares under --prefix whose files have all been
+uploaded and decrypts each one into <destination>/<org>/<share id>.
+The shares it has handled are kept in --inbox-dir, so each share is
+decrypted once even across restarts.  A share that fails is tried
+again on later passes, up to --max-attempts times.
+
+With --watch it keeps polling every --interval until it is stopped,
+finishing the share in hand first, which suits running it as a
+service.
+
+With --queue it reads the S3 event notifications for the bucket from
+an SQS queue instead of polling, and decrypts a share when its
+complete manifest is written.  A message is deleted once its share is
+decrypted.  Messages that cannot be read, fail --max-receives times or
+whose share failed --max-attempts times go to --dead-letter-queue, or to
+files in the inbox directory.
+Without --watch it stops when the queue is empty.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		inboxDir, _ := cmd.Flags().GetString("inbox-dir")
+		maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+		skipExisting, _ := cmd.Flags().GetBool("skip-existing")
+		queue, _ := cmd.Flags().GetString("queue")
+		noAck, _ := cmd.Flags().GetBool("no-ack")
+
+		opts := withWorkers(withConnection(options.Options{
+			Bucket:       viper.GetString("bucket"),
+			Region:       viper.GetString("region"),
+			LocalStore:   viper.GetString("local-store"),
+			Destination:  flagOrConfig(cmd, "destination"),
+			PrivKey:      flagOrConfig(cmd, "my-private-key"),
+			PubKey:       flagOrConfig(cmd, "my-public-key"),
+			SenderPubKey: flagOrConfig(cmd, "sender-public-key"),
+			Policy:       policyRules(),
+			NoAck:        noAck,
+		}))
+		if !hasStorage(opts) {
+			log.Fatal("Need to supply a bucket and region, or a local store.")
+		}
+		if opts.Destination == "" {
+			log.Fatal("Need to supply a --destination for the shares.")
+		}
+		if watch && interval <= 0 {
+			log.Fatal("Need a positive --interval to watch.")
+		}
+		state, err := inbox.Open(inboxDir, opts.Bucket+opts.LocalStore, prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checkTransferOptions(opts)
+		store := openStorage(opts)
+
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Infof("Received %s, stopping after the current share.", sig)
+			close(stop)
+		}()
+
+		if skipExisting {
+			skipShares(store, state, prefix, opts)
+		}
+		if queue != "" {
+			consumer := queueConsumer(cmd, queue, store, state, prefix, opts, maxAttempts)
+			failed := consumeQueue(consumer, watch, interval, stop)
+			if !watch && failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		for {
+			failed := receiveShares(store, state, prefix, opts, maxAttempts, stop)
+			if !watch {
+				if failed > 0 {
+					os.Exit(1)
+				}
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	},
+}
+
+// receiveShares makes one pass over the bucket, decrypting each complete
+// share not handled yet, oldest first.  It returns how many failed.
+func receiveShares(store storage.Storage, state *inbox.State, prefix string, opts options.Options, maxAttempts int, stop <-chan struct{}) int {
+	summaries, err := listShares(store, prefix, false, opts)
+	if err != nil {
+		log.Errorf("Unable to list the shares, %v", err)
+		return 1
+	}
+	manifest.SortSummaries(summaries, "time", false)
+
+	failed := 0
+	for _, s := range summaries {
+		select {
+		case <-stop:
+			return failed
+		default:
+		}
+		if state.Done(s.ShareID, maxAttempts) {
+			continue
+		}
+		if !s.Complete {
+			log.Debugf("Waiting for share %s, %s.", s.ShareID, s.Status())
+			continue
+		}
+		if !receiveShare(store, state, s, opts) {
+			failed++
+		}
+	}
+	return failed
+}
+
+// receiveShare decrypts a share into its own folder and records what
+// happened.  It reports whether the share was decrypted.
+func receiveShare(store storage.Storage, state *inbox.State, s manifest.Summary, opts options.Options) bool {
+	dest := inbox.Destination(opts.Destination, s.Organization, s.ShareID)
+	log.Infof("Receiving share %s from %s into %s.", s.ShareID, s.Sender, dest)
+	shareOpts := opts
+	shareOpts.Destination = dest + "/"
+	record := inbox.Share{
+		ID:           s.ShareID,
+		Manifest:     s.Manifest,
+		Organization: s.Organization,
+		Destination:  dest,
+		Outcome:      inbox.Decrypted,
+		Attempts:     1,
+	}
+	if _, n, err := decryptShare(store, s.Manifest, shareOpts); err != nil {
+		record.Outcome, record.Error = inbox.Failed, err.Error()
+	} else if n > 0 {
+		record.Outcome, record.Error = inbox.Failed, fmt.Sprintf("%d files failed", n)
+	}
+	if record.Outcome == inbox.Failed {
+		log.Errorf("Share %s failed: %s", s.ShareID, record.Error)
+	}
+	if err := state.Record(record); err != nil {
+		log.Errorf("Unable to save the inbox state, %v", err)
+	}
+	return record.Outcome == inbox.Decrypted
+}
+
+// queueConsumer decrypts the shares named in the event notifications on
+// an SQS queue.
+func queueConsumer(cmd *cobra.Command, queueURL string, store storage.Storage, state *inbox.State, prefix string, opts options.Options, maxAttempts int) *notify.Consumer {
+	deadLetterQueue, _ := cmd.Flags().GetString("dead-letter-queue")
+	maxReceives, _ := cmd.Flags().GetInt("max-receives")
+	visibility, _ := cmd.Flags().GetDuration("visibility-timeout")
+	inboxDir, _ := cmd.Flags().GetString("inbox-dir")
+
+	sess, err := s3helper.Session(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue, err := notify.NewSQS(sess, queueURL, opts.Region, visibility)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var deadLetter notify.DeadLetter
+	if deadLetterQueue != "" {
+		dlq, err := notify.NewSQS(sess, deadLetterQueue, opts.Region, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		deadLetter = notify.QueueDeadLetter(dlq)
+	} else {
+		if inboxDir == "" {
+			inboxDir = inbox.DefaultDir()
+		}
+		deadLetter = notify.FileDeadLetter(filepath.Join(inboxDir, "dead-letter"))
+	}
+
+	return &notify.Consumer{
+		Queue:       queue,
+		DeadLetter:  deadLetter,
+		MaxReceives: maxReceives,
+		Batch:       1,
+		Wait:        20 * time.Second,
+		Handle: func(o notify.Object) error {
+			if opts.Bucket != "" && o.Bucket != opts.Bucket {
+				log.Warnf("Ignoring %s in bucket %s, not %s.", o.Key, o.Bucket, opts.Bucket)
+				return nil
+			}
+			if !isManifestKey(o.Key) || !strings.HasPrefix(o.Key, prefix) {
+				return nil
+			}
+			data, err := storage.ReadObject(store, o.Key)
+			if err != nil {
+				return err
+			}
+			m, err := manifest.ParseManifest(data)
+			if err != nil {
+				return fmt.Errorf("unable to read the manifest %s, %v", o.Key, err)
+			}
+			s := manifest.Summarize(o.Key, m)
+			if share := state.Get(s.ShareID); share != nil && share.Outcome == inbox.Failed && state.Done(s.ShareID, maxAttempts) {
+				return notify.Poison(fmt.Errorf("share %s failed %d times", s.ShareID, share.Attempts))
+			}
+			if state.Done(s.ShareID, maxAttempts) {
+				log.Debugf("Share %s was already handled.", s.ShareID)
+				return nil
+			}
+			if !s.Complete {
+				// The manifest is written again when the share is done.
+				log.Infof("Waiting for share %s, %s.", s.ShareID, s.Status())
+				return nil
+			}
+			if !receiveShare(store, state, s, opts) {
+				if state.Done(s.ShareID, maxAttempts) {
+					return notify.Poison(fmt.Errorf("share %s failed %d times", s.ShareID, maxAttempts))
+				}
+				return fmt.Errorf("share %s failed", s.ShareID)
+			}
+			return nil
+		},
+	}
+}
+
+// consumeQueue handles messages until stopped or, unless watching, until
+// the queue is empty.  It returns how many messages failed.
+func consumeQueue(consumer *notify.Consumer, watch bool, interval time.Duration, stop <-chan struct{}) int {
+	failed := 0
+	for {
+		select {
+		case <-stop:
+			return failed
+		default:
+		}
+		received, n, err := consumer.Poll()
+		failed += n
+		if err != nil {
+			log.Errorf("Unable to receive from the queue, %v", err)
+			if !watch {
+				return failed + 1
+			}
+			select {
+			case <-stop:
+				return failed
+			case <-time.After(interval):
+			}
+			continue
+		}
+		if received == 0 && !watch {
+			return failed
+		}
+	}
+}
+
+// skipShares marks every share already in the bucket as handled, so
+// that only shares made from now on are decrypted.
+func skipShares(store storage.Storage, state *inbox.State, prefix string, opts options.Options) {
+	summaries, err := listShares(store, prefix, false, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, s := range summaries {
+		if state.Get(s.ShareID) != nil || !s.Complete {
+			continue
+		}
+		if err := state.Record(inbox.Share{ID: s.ShareID, Manifest: s.Manifest, Organization: s.Organization, Outcome: inbox.Skipped}); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(receiveCmd)
+
+	receiveCmd.Flags().String("destination", "", "The directory to decrypt shares into, one folder per org and share.")
+	receiveCmd.Flags().String("my-private-key", "", "The receiver's private key.  A local file path.")
+	receiveCmd.Flags().String("my-public-key", "", "The receiver's public key.  A local file path.")
+	receiveCmd.Flags().String("sender-public-key", "", "The sender's public key.  If given, manifest signatures must verify.")
+	receiveCmd.Flags().Bool("no-ack", false, "Do not write signed acknowledgements of the files received back into the shares.")
+	receiveCmd.Flags().String("prefix", "", "Only receive shares under this prefix.")
+	receiveCmd.Flags().Bool("watch", false, "Keep polling the bucket for new shares.")
+	receiveCmd.Flags().Duration("interval", time.Minute, "How often to poll with --watch.")
+	receiveCmd.Flags().String("inbox-dir", "", "Where to remember the shares handled (default $HOME/.s3s2/inbox).")
+	receiveCmd.Flags().Int("max-attempts", 3, "How many times to try a share that fails, 0 for no limit.")
+	receiveCmd.Flags().Bool("skip-existing", false, "Mark the shares already in the bucket as handled without decrypting them.")
+	receiveCmd.Flags().String("queue", "", "The URL of an SQS queue with the bucket's event notifications, instead of polling.")
+	receiveCmd.Flags().String("dead-letter-queue", "", "The URL of an SQS queue for messages that cannot be handled (default is files in the inbox directory).")
+	receiveCmd.Flags().Int("max-receives", 5, "How many times a message may fail before it is dead lettered.")
+	receiveCmd.Flags().Duration("visibility-timeout", 15*time.Minute, "How long a received message is hidden while its share is decrypted.")
+}
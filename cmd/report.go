@@ -0,0 +1,44 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+)
+
+// report prints the outcome of a series of checks and keeps
+// count of the ones that failed.
+type report struct {
+	failures int
+	warnings int
+}
+
+func (r *report) pass(format string, args ...interface{}) {
+	fmt.Printf("PASS\t"+format+"\n", args...)
+}
+
+func (r *report) warn(format string, args ...interface{}) {
+	r.warnings++
+	fmt.Printf("WARN\t"+format+"\n", args...)
+}
+
+func (r *report) fail(format string, args ...interface{}) {
+	r.failures++
+	fmt.Printf("FAIL\t"+format+"\n", args...)
+}
+
+func (r *report) summary() {
+	fmt.Printf("%d failed, %d warnings.\n", r.failures, r.warnings)
+}
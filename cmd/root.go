@@ -16,6 +16,8 @@ package cmd
 
 import (
 	"os"
+	"runtime"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
@@ -30,6 +32,10 @@ var region string
 var pubkey string
 var privkey string
 
+// Version of s3s2.  Releases set it with
+// -ldflags "-X github.com/jemurai/s3s2/cmd.Version=<version>".
+var Version = "dev"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "s3s2",
@@ -57,15 +63,68 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.Version = Version
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.s3s2.yaml)")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "debug mode")
 	rootCmd.PersistentFlags().StringVar(&bucket, "bucket", "", "The bucket to work with.")
 	rootCmd.PersistentFlags().StringVar(&region, "region", "", "The region the bucket is in.")
+	rootCmd.PersistentFlags().String("local-store", "", "Keep shares in this local directory instead of an S3 bucket.")
+	rootCmd.PersistentFlags().String("ledger", "", "The audit ledger file (default is $HOME/.s3s2/audit.log)")
+	rootCmd.PersistentFlags().String("endpoint", "", "The URL of an S3 compatible store, such as MinIO or Ceph.")
+	rootCmd.PersistentFlags().Bool("path-style", false, "Address the bucket in the URL path instead of the host name.")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "A PEM file of CA certificates to trust for the endpoint.")
+	rootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "Do not verify the endpoint's TLS certificate.  Only for testing.")
+	rootCmd.PersistentFlags().String("sse", "", "Server side encryption: aws:kms, AES256, sse-c or none (default is sse-c with a customer key, aws:kms when --awskey is given).")
+	rootCmd.PersistentFlags().String("sse-customer-key-file", "", "A file holding the 256 bit SSE-C key, raw or base64 (or set $S3S2_SSE_CUSTOMER_KEY).")
+	rootCmd.PersistentFlags().String("storage-class", "", "The storage class of uploaded objects, such as STANDARD_IA (default is the bucket's).")
+	rootCmd.PersistentFlags().Bool("no-checksums", false, "Do not send SHA-256 checksums for S3 to check, for S3 compatible stores that refuse them.")
+	rootCmd.PersistentFlags().Int64("part-size", 16, "The part size in MiB for multipart uploads and ranged downloads.")
+	rootCmd.PersistentFlags().Int("concurrency", 5, "The parts of each file to transfer at once.")
+	rootCmd.PersistentFlags().Int("max-connections", 32, "The most connections to open to the store across all files.")
+	rootCmd.PersistentFlags().String("max-bandwidth", "", "The most bandwidth for all transfers to S3 together, such as 10MB or 20Mbit a second (default is no limit).")
+	rootCmd.PersistentFlags().StringSlice("transfer-window", nil, "Only transfer in this time of day, such as 19:00-06:00.  Can be given more than once.")
+	rootCmd.PersistentFlags().String("state-dir", "", "Where to keep the state of interrupted uploads (default is $HOME/.s3s2/uploads)")
+	rootCmd.PersistentFlags().Int("parallel", 16, "The most files to work on at once.")
+	rootCmd.PersistentFlags().Int("cpu-workers", runtime.NumCPU(), "The files to compress, encrypt or decrypt at once.")
+	rootCmd.PersistentFlags().Int("io-workers", 8, "The files to upload or download at once.")
+	rootCmd.PersistentFlags().Int("retries", 3, "How often to retry a file that failed to upload or download.")
+	rootCmd.PersistentFlags().Duration("retry-wait", time.Second, "The wait before the first retry.  It doubles, with jitter, for each retry.")
 
 	viper.BindPFlag("bucket", rootCmd.PersistentFlags().Lookup("bucket"))
 	viper.BindPFlag("region", rootCmd.PersistentFlags().Lookup("region"))
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	viper.BindPFlag("local-store", rootCmd.PersistentFlags().Lookup("local-store"))
+	viper.BindPFlag("ledger", rootCmd.PersistentFlags().Lookup("ledger"))
+	viper.BindPFlag("endpoint", rootCmd.PersistentFlags().Lookup("endpoint"))
+	viper.BindPFlag("path-style", rootCmd.PersistentFlags().Lookup("path-style"))
+	viper.BindPFlag("ca-bundle", rootCmd.PersistentFlags().Lookup("ca-bundle"))
+	viper.BindPFlag("insecure-skip-verify", rootCmd.PersistentFlags().Lookup("insecure-skip-verify"))
+	viper.BindPFlag("sse", rootCmd.PersistentFlags().Lookup("sse"))
+	viper.BindPFlag("sse-customer-key-file", rootCmd.PersistentFlags().Lookup("sse-customer-key-file"))
+	viper.BindPFlag("storage-class", rootCmd.PersistentFlags().Lookup("storage-class"))
+	viper.BindPFlag("no-checksums", rootCmd.PersistentFlags().Lookup("no-checksums"))
+	viper.BindPFlag("part-size", rootCmd.PersistentFlags().Lookup("part-size"))
+	viper.BindPFlag("concurrency", rootCmd.PersistentFlags().Lookup("concurrency"))
+	viper.BindPFlag("max-connections", rootCmd.PersistentFlags().Lookup("max-connections"))
+	viper.BindPFlag("max-bandwidth", rootCmd.PersistentFlags().Lookup("max-bandwidth"))
+	viper.BindPFlag("transfer-window", rootCmd.PersistentFlags().Lookup("transfer-window"))
+	viper.BindPFlag("state-dir", rootCmd.PersistentFlags().Lookup("state-dir"))
+	viper.BindPFlag("parallel", rootCmd.PersistentFlags().Lookup("parallel"))
+	viper.BindPFlag("cpu-workers", rootCmd.PersistentFlags().Lookup("cpu-workers"))
+	viper.BindPFlag("io-workers", rootCmd.PersistentFlags().Lookup("io-workers"))
+	viper.BindPFlag("retries", rootCmd.PersistentFlags().Lookup("retries"))
+	viper.BindPFlag("retry-wait", rootCmd.PersistentFlags().Lookup("retry-wait"))
+
+}
 
+// flagOrConfig reads an option from the command's own flag if it was set
+// and otherwise from the config file.  It lets commands share option names
+// without fighting over a single viper flag binding.
+func flagOrConfig(cmd *cobra.Command, name string) string {
+	if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+		return flag.Value.String()
+	}
+	return viper.GetString(name)
 }
 
 // initConfig reads in config file and ENV variables if set.
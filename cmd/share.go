@@ -15,8 +15,10 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -26,10 +28,13 @@ import (
 	"github.com/spf13/viper"
 
 	archive "github.com/jemurai/s3s2/archive"
+	audit "github.com/jemurai/s3s2/audit"
 	encrypt "github.com/jemurai/s3s2/encrypt"
 	manifest "github.com/jemurai/s3s2/manifest"
 	options "github.com/jemurai/s3s2/options"
 	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
+	ticket "github.com/jemurai/s3s2/ticket"
 	utils "github.com/jemurai/s3s2/utils"
 )
 
@@ -46,55 +51,424 @@ that it will be encrypted.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		opts := buildShareOptions(cmd)
+		if opts.DryRun {
+			dryRun(opts)
+			return
+		}
+		var grant *ticket.Ticket
+		if opts.Ticket != "" {
+			t := readTicket(&opts)
+			grant = &t
+		}
 		checkShareOptions(opts)
-		fnuuid, _ := uuid.NewV4()
-		folder := opts.Prefix + "_s3s2_" + fnuuid.String()
-		m := manifest.BuildManifest(folder, opts)
+		if opts.Preflight {
+			preflight(opts)
+		}
+		if opts.Resume != "" {
+			failed := resumeShare(opts)
+			timing(start, "Elasped time: %f")
+			if failed > 0 {
+				fmt.Printf("Run s3s2 share --resume %s again to upload the rest.\n", opts.Resume)
+				os.Exit(1)
+			}
+			printLink(openStorage(opts), manifestKey(opts.Resume), opts)
+			return
+		}
+		m := manifest.BuildManifest("", opts)
+		if opts.LocalStore == "" {
+			m.SSE, m.SSEKeyMD5 = serverSideEncryption(opts)
+		}
+		// The KMS key is only recorded when objects are encrypted with it.
+		if m.SSE != "aws:kms" {
+			m.AwsKey = ""
+		}
+		if opts.Retention > 0 {
+			expires := m.Timestamp.Add(opts.Retention).UTC()
+			m.Expires = &expires
+		}
+		if err := applyLabels(&m, opts); err != nil {
+			log.Fatal(err)
+		}
+		enforcePolicy(opts.Policy, m.AllLabels(), shareProtection(opts))
+		folder := shareFolder(opts, m)
+		if grant != nil {
+			folder = grant.Folder
+			m.Bucket = grant.Bucket
+		}
+		m.Folder = folder
+		opts.WorkDir = shareWorkDir(opts, folder)
+		files := m.Files
+		if opts.Since != "" {
+			if stat, err := os.Stat(opts.Since); err == nil && stat.IsDir() {
+				log.Fatalf("%s is a directory, --since needs a previous manifest.", opts.Since)
+			}
+			previous, err := loadManifest(opts.Since, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			files = m.BuildOn(previous)
+			log.Infof("%d of %d files changed since %s", len(files), len(m.Files), previous.Folder)
+		}
 
-		if err := s3helper.UploadFile(folder, opts.Directory+m.Name, opts); err != nil {
-			log.Error(err)
+		if opts.PubKey != "" {
+			recipient, err := encrypt.Fingerprint(opts.PubKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			m.Recipients = []string{recipient}
+		}
+		if opts.SenderPrivKey != "" && m.Root == "" {
+			log.Fatal("Only hashed shares can be signed, use --hash.")
 		}
-		utils.CleanupFile(opts.Directory + m.Name)
 
-		var wg sync.WaitGroup
-		for i := 0; i < len(m.Files); i++ {
-			wg.Add(1)
-			fn := m.Files[i].Name
-			go func(folder string, fn string, opts options.Options) {
-				defer wg.Done()
-				processFile(folder, fn, opts)
-			}(folder, fn, opts)
+		var store storage.Storage
+		if grant != nil {
+			if len(files) > len(grant.Files) {
+				log.Fatalf("The ticket allows %d files, the share has %d.", len(grant.Files), len(files))
+			}
+			client, err := s3helper.HTTPClient(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			limiter, err := s3helper.Limiter(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			store = grant.Store(client, limiter)
+		} else {
+			store = openStorage(opts)
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
 		}
-		wg.Wait()
+		m.Pending = names
+		uploadManifest(store, &m, opts)
+
+		failed := sendFiles(store, &m, files, opts)
 		timing(start, "Elasped time: %f")
+		if failed > 0 {
+			fmt.Printf("Run s3s2 share --resume %s to upload the rest.\n", m.Folder)
+			os.Exit(1)
+		}
+		printLink(store, manifestKey(m.Folder), opts)
 	},
 }
 
-func processFile(folder string, fn string, options options.Options) {
+// printLink prints a presigned link to a share that has been sent,
+// when asked to.
+func printLink(store storage.Storage, key string, options options.Options) {
+	if options.Presign == 0 {
+		return
+	}
+	link, err := presignShare(store, key, options.Presign, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Download link, valid for %s:\n%s\n", options.Presign, link)
+}
+
+// sendFiles compresses, encrypts and uploads files into the share and
+// uploads the manifest again with the objects they went to.  A file
+// that fails is retried and then left pending, without stopping the
+// others.  It returns how many files failed.
+func sendFiles(store storage.Storage, m *manifest.Manifest, files []manifest.FileDescription, opts options.Options) int {
+	// Compressing and encrypting is CPU bound and uploading is IO
+	// bound, so each gets its own workers.  Files wait between the
+	// two, holding their temp files, for at most --parallel files.
+	prepared := make([]string, len(files))
+	uploads := make([]storage.ObjectInfo, len(files))
+	errs := runFiles(opts, len(files),
+		cpuStage(opts, func(i int) error {
+			// An interrupted upload can only resume from the same
+			// encrypted file, so it must not be built again.
+			if fn := preparedName(files[i].Name, opts); storage.Resumable(store, fn) {
+				prepared[i] = fn
+				return nil
+			}
+			fn, err := prepareFile(files[i].Name, opts)
+			if err != nil {
+				// The failed file is cleaned up with the others.
+				prepared[i] = preparedName(files[i].Name, opts)
+				return err
+			}
+			prepared[i] = fn
+			return nil
+		}),
+		ioStage(opts, func(i int) error {
+			put := fileOptions(*m, files[i])
+			return retryPolicy(opts).Do("Upload of "+files[i].Name, func() error {
+				var err error
+				uploads[i], err = uploadFile(store, m.Folder, prepared[i], put, opts)
+				return err
+			})
+		}),
+	)
+
+	// Upload the manifest again, now with the keys, ETags and checksums of
+	// the objects.
+	var names []string
+	for i, u := range uploads {
+		names = append(names, files[i].Name)
+		if errs[i] == nil {
+			m.Uploaded(files[i].Name, u.Key, u.ETag)
+			m.Checksummed(files[i].Name, u.Checksum, u.PartSize)
+		} else {
+			cleanupPrepared(store, prepared[i])
+		}
+	}
+	uploadManifest(store, m, opts)
+	failed := summarize(names, errs)
+	if failed == 0 {
+		utils.CleanupDirectory(opts.WorkDir)
+	}
+
+	outcome := audit.Success
+	if failed > 0 {
+		outcome = audit.Failure
+	}
+	recordAudit(audit.Entry{
+		Action:         audit.Share,
+		ShareID:        m.Folder,
+		ManifestDigest: manifest.Digest(*m),
+		Outcome:        outcome,
+		Detail:         fmt.Sprintf("%d of %d files uploaded", len(files)-failed, len(m.Files)),
+	}, opts)
+	return failed
+}
+
+// resumeShare finishes a share that stopped part way.  It compares
+// the manifest with what is actually in the bucket and uploads only the
+// files that are missing.  It returns how many still failed.
+func resumeShare(opts options.Options) int {
+	store := openStorage(opts)
+	key := manifestKey(opts.Resume)
+	m, err := loadManifest(key, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.WorkDir = shareWorkDir(opts, m.Folder)
+	enforcePolicy(opts.Policy, m.AllLabels(), shareProtection(opts))
+
+	// The files have to be the ones the share started with, or the
+	// manifest, its Merkle root and signature would not match them.
+	scan := opts
+	scan.Hash = m.Hashed()
+	d := manifest.DiffFiles(m.Files, manifest.BuildManifest("", scan).Files)
+	if d.Changed() {
+		printDiff(d)
+		log.Fatalf("%s changed since the share started, share it again instead.", opts.Directory)
+	}
+	if m.Signature != "" {
+		signer, err := encrypt.Fingerprint(opts.SenderPubKey)
+		if opts.SenderPrivKey == "" || err != nil || signer != m.Signer {
+			log.Fatalf("The share was signed by %s, give its --sender-public-key and --sender-private-key to resume it.", m.Signer)
+		}
+	}
+	if opts.PubKey != "" && len(m.Recipients) > 0 {
+		recipient, err := encrypt.Fingerprint(opts.PubKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if recipient != m.Recipients[0] {
+			log.Fatalf("The share was encrypted for %s, not %s.", m.Recipients[0], recipient)
+		}
+	}
+
+	var missing []manifest.FileDescription
+	var names []string
+	for _, f := range m.Files {
+		if !inStorage(store, &m, f, opts) {
+			missing = append(missing, f)
+			names = append(names, f.Name)
+		}
+	}
+	log.Infof("%d of %d files are missing from %s.", len(missing), len(m.Files), m.Folder)
+	m.Pending = names
+	return sendFiles(store, &m, missing, opts)
+}
+
+// manifestKey is the key of the manifest of a share, given the share
+// id or the key itself.
+func manifestKey(share string) string {
+	if strings.HasSuffix(share, ".json") {
+		return share
+	}
+	return filepath.Clean(share + "/" + manifest.FileName)
+}
+
+// inStorage reports whether a file of the share is in the store.  A
+// file that was uploaded but never recorded in the manifest, because
+// the share stopped first, is recorded now.
+func inStorage(store storage.Storage, m *manifest.Manifest, f manifest.FileDescription, opts options.Options) bool {
+	key := f.Key
+	if key == "" && f.Folder != "" {
+		key = m.ObjectKey(f)
+	} else if key == "" {
+		key = objectKey(m.Folder, preparedName(f.Name, opts), opts)
+	}
+	info, err := store.Stat(key)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			log.Warn(err)
+		}
+		return false
+	}
+	if f.ETag != "" && info.ETag != "" && f.ETag != info.ETag {
+		log.Warnf("%s does not match the object uploaded for it.", f.Name)
+		return false
+	}
+	if f.Checksum != "" && info.Checksum != "" && f.Checksum != info.Checksum {
+		log.Warnf("%s does not match the checksum of the object uploaded for it.", f.Name)
+		return false
+	}
+	if f.Key == "" {
+		m.Uploaded(f.Name, key, info.ETag)
+		m.Checksummed(f.Name, info.Checksum, info.PartSize)
+	}
+	return true
+}
+
+// shareFolder names the folder a share is uploaded to.  A hashed share
+// is named for the start of its Merkle root, with a short random suffix
+// so that sharing the same files again gets a folder of its own.  Other
+// shares get a random id.
+func shareFolder(options options.Options, m manifest.Manifest) string {
+	fnuuid, _ := uuid.NewV4()
+	if m.Root == "" {
+		return options.Prefix + "_s3s2_" + fnuuid.String()
+	}
+	return options.Prefix + "_s3s2_" + m.Root[:16] + "-" + fnuuid.String()[:8]
+}
+
+// signManifest signs the digest of the whole manifest with the sender's
+// key.  It has to be signed again after every change.
+func signManifest(m *manifest.Manifest, pubkey string, privkey string) error {
+	if m.Root == "" {
+		return fmt.Errorf("only hashed shares can be signed, use --hash")
+	}
+	signer, err := encrypt.Fingerprint(pubkey)
+	if err != nil {
+		return err
+	}
+	m.Signer = signer
+	signature, err := encrypt.Sign([]byte(manifest.SignedDigest(*m)), pubkey, privkey)
+	if err != nil {
+		return err
+	}
+	m.Signature = signature
+	return nil
+}
+
+// uploadManifest signs the manifest if there is a sender key, writes it
+// into the work directory, uploads it into the share folder and cleans
+// it up again.
+func uploadManifest(store storage.Storage, m *manifest.Manifest, options options.Options) {
+	if options.SenderPrivKey != "" {
+		if err := signManifest(m, options.SenderPubKey, options.SenderPrivKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+	dir := options.WorkDir
+	if dir == "" {
+		dir = options.Directory
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	if err := manifest.WriteManifest(*m, dir); err != nil {
+		log.Fatal(err)
+	}
+	fn := dir + m.Name
+	if _, err := storage.PutFile(store, objectKey(m.Folder, fn, options), fn, manifestOptions(*m)); err != nil {
+		log.Error(err)
+	}
+	utils.CleanupFile(fn)
+}
+
+// dryRun lists what a share would include and exclude without
+// archiving, encrypting or uploading anything.
+func dryRun(options options.Options) {
+	options.Hash = false
+	files, excluded, err := manifest.ScanDirectory(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		fmt.Printf("include\t%s\n", f.Name)
+	}
+	for _, e := range excluded {
+		fmt.Printf("exclude\t%s\n", e)
+	}
+	fmt.Printf("%d files would be shared, %d paths excluded.\n", len(files), len(excluded))
+}
+
+// prepareFile compresses and, with a receiver key, encrypts a file
+// and returns the name of the file to upload.
+func prepareFile(fn string, options options.Options) (string, error) {
 	log.Debugf("Processing %s", fn)
 	start := time.Now()
-	fn = archive.ZipFile(options.Directory+fn, options)
-	//fn = archive.ZipFile(fn)
+	fn, err := archive.ZipFile(options.Directory+fn, options)
+	if err != nil {
+		return fn, err
+	}
 	archiveTime := timing(start, "\tArchive time (sec): %f")
 	log.Debugf("\tCompressing file: %s", fn)
 	if options.PubKey != "" {
-		encrypt.Encrypt(fn, options.PubKey)
+		if err := encrypt.Encrypt(fn, options.PubKey); err != nil {
+			return fn, err
+		}
+		fn = fn + ".gpg"
+	}
+	timing(archiveTime, "\tEncrypt time (sec): %f")
+	return fn, nil
+}
+
+// preparedName is the file prepareFile builds for a shared file.
+func preparedName(fn string, options options.Options) string {
+	if options.WorkDir != "" {
+		fn = filepath.Join(options.WorkDir, fn) + ".zip"
+	} else {
+		fn = options.Directory + fn + ".zip"
+	}
+	if options.PubKey != "" {
 		fn = fn + ".gpg"
 	}
-	encryptTime := timing(archiveTime, "\tEncrypt time (sec): %f")
-	err := s3helper.UploadFile(folder, fn, options)
+	return fn
+}
+
+// uploadFile uploads a prepared file into the share folder and
+// cleans up its temp files.  They are kept if the upload fails, so
+// that a retry can use them.
+func uploadFile(store storage.Storage, folder string, fn string, put storage.PutOptions, options options.Options) (storage.ObjectInfo, error) {
+	start := time.Now()
+	var upload storage.ObjectInfo
+	err := inWindow(options, func() error {
+		var err error
+		upload, err = storage.PutFile(store, objectKey(folder, fn, options), fn, put)
+		return err
+	})
 	if err != nil {
-		log.Fatal(err)
+		return upload, err
 	}
+	cleanupPrepared(nil, fn)
 
+	timing(start, "\tUpload time (sec): %f")
+	log.Debugf("\tProcessed %s", fn)
+	return upload, nil
+}
+
+// cleanupPrepared removes the temp files for a prepared file, unless
+// the store can still resume its upload from them.
+func cleanupPrepared(store storage.Storage, fn string) {
+	if fn == "" || (store != nil && storage.Resumable(store, fn)) {
+		return
+	}
 	utils.CleanupFile(fn)
 	if strings.HasSuffix(fn, ".gpg") {
 		zipName := strings.TrimSuffix(fn, ".gpg")
 		utils.CleanupFile(zipName)
 	}
-
-	timing(encryptTime, "\tUpload time (sec): %f")
-	log.Debugf("\tProcessed %s", fn)
 }
 
 func timing(start time.Time, message string) time.Time {
@@ -110,23 +484,64 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 	directory := viper.GetString("directory")
 	bucket := viper.GetString("bucket")
 	region := viper.GetString("region")
+	localStore := viper.GetString("local-store")
 	pubKey := viper.GetString("receiver-public-key")
 	awsKey := viper.GetString("awskey")
 	org := viper.GetString("org")
 	prefix := viper.GetString("prefix")
 	hash := viper.GetBool("hash")
+	include := viper.GetStringSlice("include")
+	exclude := viper.GetStringSlice("exclude")
+	dry, _ := cmd.Flags().GetBool("dry-run")
+	senderPubKey := viper.GetString("sender-public-key")
+	senderPrivKey := viper.GetString("sender-private-key")
+	labels := viper.GetStringSlice("labels")
+	fileLabels := viper.GetStringSlice("file-labels")
+	since, _ := cmd.Flags().GetString("since")
+	resume, _ := cmd.Flags().GetString("resume")
+	presign, _ := cmd.Flags().GetDuration("presign")
+	ticketFile, _ := cmd.Flags().GetString("ticket")
+	preflight := viper.GetBool("preflight")
+	retention := viper.GetDuration("retention")
+	workDir := viper.GetString("work-dir")
+	if since != "" || senderPrivKey != "" {
+		// We can only tell what changed, or sign what we share,
+		// if we know the content by its hash.
+		hash = true
+	}
 
 	options := options.Options{
-		Directory: directory,
-		Bucket:    bucket,
-		Region:    region,
-		PubKey:    pubKey,
-		AwsKey:    awsKey,
-		Org:       org,
-		Prefix:    prefix,
-		Hash:      hash,
+		Directory:  directory,
+		Bucket:     bucket,
+		Region:     region,
+		LocalStore: localStore,
+		PubKey:     pubKey,
+		AwsKey:     awsKey,
+		Org:        org,
+		Prefix:     prefix,
+		Hash:       hash,
+		Include:    include,
+		Exclude:    exclude,
+		DryRun:     dry,
+		Since:      since,
+		Resume:     resume,
+		Presign:    presign,
+		Ticket:     ticketFile,
+		Preflight:  preflight,
+		Retention:  retention,
+		WorkDir:    workDir,
+
+		SenderPubKey:  senderPubKey,
+		SenderPrivKey: senderPrivKey,
+
+		Labels:     labels,
+		FileLabels: fileLabels,
+		Policy:     policyRules(),
 	}
 
+	options = withConnection(options)
+	options = withWorkers(options)
+
 	debug := viper.GetBool("debug")
 	if debug != true {
 		log.SetLevel(log.InfoLevel)
@@ -138,12 +553,56 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 }
 
 func checkShareOptions(options options.Options) {
-	if options.AwsKey != "" || options.PubKey != "" {
+	if err := s3helper.CheckServerSideEncryption(options); err != nil {
+		log.Fatal(err)
+	}
+	sse := s3helper.ServerSideEncryption(options)
+	if sse == "aws:kms" || sse == s3helper.SSECustomer || options.PubKey != "" {
 		// OK, that's good.  Looks like we have a key.
 	} else {
 		log.Warn("Need to supply either AWS Key for S3 level encryption or a public key for GPG encryption or both!")
 		log.Panic("Insufficient key material to perform safe encryption.")
 	}
+	checkTransferOptions(options)
+	if sse != s3helper.SSECustomer && options.LocalStore == "" {
+		if key, err := s3helper.CustomerKey(options); err != nil || key != nil {
+			log.Fatalf("--sse %s conflicts with the %s key given with --sse-customer-key-file or $%s, drop one of them.", options.SSE, s3helper.SSECustomer, s3helper.CustomerKeyEnv)
+		}
+	}
+	if sse == s3helper.SSECustomer && options.LocalStore == "" {
+		if options.AwsKey != "" {
+			log.Fatalf("Objects are encrypted with either a KMS key or an %s key, not both.  Drop --awskey or the %s key.", s3helper.SSECustomer, s3helper.SSECustomer)
+		}
+		if options.Presign != 0 {
+			log.Fatalf("Presigned links can not be used with %s, the receiver would need the key for every request.", s3helper.SSECustomer)
+		}
+		serverSideEncryption(options)
+	}
+	if options.AwsKey != "" && sse != "aws:kms" {
+		log.Warnf("--awskey is not used with --sse %s, the objects are not encrypted with it.", options.SSE)
+	}
+	if options.Presign != 0 {
+		if options.LocalStore != "" {
+			log.Fatal("Presigned links need an S3 bucket, not a local store.")
+		}
+		if options.Presign < 0 || options.Presign > s3helper.MaxPresign {
+			log.Fatalf("Presigned links must expire within %s.", s3helper.MaxPresign)
+		}
+	}
+}
+
+// preflight runs the doctor checks on the bucket and stops the share
+// if any of them fail or could not be checked.
+func preflight(opts options.Options) {
+	if opts.LocalStore != "" || opts.Ticket != "" {
+		log.Warn("Skipping the preflight checks, they need access to an S3 bucket.")
+		return
+	}
+	r := &report{}
+	checkPosture(r, opts, true)
+	if r.failures > 0 {
+		log.Fatalf("The bucket failed %d preflight checks, see s3s2 doctor.", r.failures)
+	}
 }
 
 func init() {
@@ -154,16 +613,40 @@ func init() {
 	shareCmd.PersistentFlags().String("org", "", "The organization that owns the files.")
 	shareCmd.MarkFlagRequired("org")
 	shareCmd.PersistentFlags().String("prefix", "", "A prefix for the S3 path.")
+	shareCmd.PersistentFlags().String("work-dir", "", "Where to compress and encrypt files until they are uploaded, outside the directory being shared (default is $HOME/.s3s2/work)")
 	shareCmd.PersistentFlags().String("awskey", "", "The agreed upon S3 key to encrypt data with at the bucket.")
 	shareCmd.PersistentFlags().String("receiver-public-key", "", "The receiver's public key.  A local file path.")
 	shareCmd.PersistentFlags().Bool("hash", false, "Should the tool calculate hashes (slow)?")
+	shareCmd.PersistentFlags().StringSlice("include", []string{}, "Only share files matching these patterns (gitignore style).")
+	shareCmd.PersistentFlags().StringSlice("exclude", []string{}, "Do not share files matching these patterns (gitignore style).")
+	shareCmd.PersistentFlags().String("resume", "", "The id (folder) of a share that stopped part way.  Upload only the files missing from it.")
+	shareCmd.PersistentFlags().Duration("presign", 0, "Print a link to download the share without AWS credentials, valid this long (at most 168h).")
+	shareCmd.PersistentFlags().String("ticket", "", "A ticket from the receiver's s3s2 grant.  Upload through its links, without AWS credentials.")
+	shareCmd.PersistentFlags().Bool("preflight", false, "Check the bucket is set up safely, as s3s2 doctor does, and refuse to share if it is not.")
+	shareCmd.PersistentFlags().Duration("retention", 0, "How long the share should be kept, such as 720h.  Sets the expires tag on its objects.")
+	shareCmd.PersistentFlags().String("since", "", "A previous manifest (local file or bucket key).  Only upload files changed since then.")
+	shareCmd.PersistentFlags().StringSlice("labels", []string{}, "Classification labels for the whole share, such as PII or PHI.")
+	shareCmd.PersistentFlags().StringSlice("file-labels", []string{}, "Labels for matching files, as pattern=LABEL[,LABEL].")
+	shareCmd.PersistentFlags().Bool("dry-run", false, "List the files that would be shared and excluded without sharing them.")
+
+	shareCmd.PersistentFlags().String("sender-public-key", "", "The sender's public key, used to sign the manifest.  A local file path.")
+	shareCmd.PersistentFlags().String("sender-private-key", "", "The sender's private key, used to sign the manifest.  A local file path.")
 
 	viper.BindPFlag("directory", shareCmd.PersistentFlags().Lookup("directory"))
 	viper.BindPFlag("org", shareCmd.PersistentFlags().Lookup("org"))
 	viper.BindPFlag("prefix", shareCmd.PersistentFlags().Lookup("prefix"))
+	viper.BindPFlag("work-dir", shareCmd.PersistentFlags().Lookup("work-dir"))
 	viper.BindPFlag("awskey", shareCmd.PersistentFlags().Lookup("awskey"))
 	viper.BindPFlag("receiver-public-key", shareCmd.PersistentFlags().Lookup("receiver-public-key"))
 	viper.BindPFlag("hash", shareCmd.PersistentFlags().Lookup("hash"))
+	viper.BindPFlag("sender-public-key", shareCmd.PersistentFlags().Lookup("sender-public-key"))
+	viper.BindPFlag("sender-private-key", shareCmd.PersistentFlags().Lookup("sender-private-key"))
+	viper.BindPFlag("labels", shareCmd.PersistentFlags().Lookup("labels"))
+	viper.BindPFlag("file-labels", shareCmd.PersistentFlags().Lookup("file-labels"))
+	viper.BindPFlag("include", shareCmd.PersistentFlags().Lookup("include"))
+	viper.BindPFlag("exclude", shareCmd.PersistentFlags().Lookup("exclude"))
+	viper.BindPFlag("preflight", shareCmd.PersistentFlags().Lookup("preflight"))
+	viper.BindPFlag("retention", shareCmd.PersistentFlags().Lookup("retention"))
 
 	//log.SetFormatter(&log.JSONFormatter{})
 	log.SetFormatter(&log.TextFormatter{})
@@ -0,0 +1,260 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	ack "github.com/jemurai/s3s2/ack"
+	audit "github.com/jemurai/s3s2/audit"
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the receiver has retrieved a share",
+	Long: `Show whether the receiver has retrieved a share.
+
+When decrypt or receive finishes a share, it hashes the files against
+the manifest and writes an acknowledgement, signed with the receiver's
+key, into the share's s3s2_acks folder.  status reads them and shows
+the share as delivered, partially delivered or not yet retrieved, and
+which files are still missing.
+
+Acks are checked against --receiver-public-key, and ones that do not
+verify are not counted.  Without the key, acks from keys the share was
+not encrypted for are ignored and the rest are shown as unchecked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		share, _ := cmd.Flags().GetString("share")
+		format, _ := cmd.Flags().GetString("format")
+		opts := options.Options{
+			Bucket:     viper.GetString("bucket"),
+			Region:     viper.GetString("region"),
+			LocalStore: viper.GetString("local-store"),
+			PubKey:     flagOrConfig(cmd, "receiver-public-key"),
+		}
+		if share == "" {
+			log.Fatal("Need to supply the share to check with --share.")
+		}
+		if !hasStorage(opts) {
+			log.Fatal("Need to supply a bucket and region, or a local store.")
+		}
+		store := openStorage(opts)
+		key := manifestKey(share)
+		data, err := storage.ReadObject(store, key)
+		if err != nil {
+			log.Fatalf("Unable to read the manifest %s, %v", key, err)
+		}
+		m, err := manifest.ParseManifest(data)
+		if err != nil {
+			log.Fatalf("Unable to read the manifest %s, %v", key, err)
+		}
+		acks, err := readAcks(store, path.Dir(key), m, opts.PubKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names := deliveredNames(m)
+		d := ack.Deliver(acks, names)
+
+		switch format {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if acks == nil {
+				acks = []ack.Ack{}
+			}
+			err := enc.Encode(struct {
+				Share string `json:"share_id"`
+				ack.Delivery
+				Acks []ack.Ack `json:"acks"`
+			}{path.Dir(key), d, acks})
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "table", "":
+			printDelivery(path.Dir(key), d, acks, len(names))
+		default:
+			log.Fatalf("Unknown format %q, use table or json.", format)
+		}
+	},
+}
+
+// readAcks reads the acknowledgements in a share folder and keeps the
+// ones that count: signed by the receiver's key when it is given, or
+// else by a key the share was encrypted for.
+func readAcks(store storage.Storage, folder string, m manifest.Manifest, pubkey string) ([]ack.Ack, error) {
+	objects, err := store.List(ack.Prefix(folder))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the acknowledgements of %s, %v", folder, err)
+	}
+	digest := manifest.Digest(m)
+	unchecked := 0
+	var acks []ack.Ack
+	for _, o := range objects {
+		data, err := storage.ReadObject(store, o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the acknowledgement %s, %v", o.Key, err)
+		}
+		a, err := ack.Parse(data)
+		if err != nil {
+			log.Warnf("Ignoring %s, it is not an acknowledgement, %v", o.Key, err)
+			continue
+		}
+		if pubkey != "" {
+			if err := a.Verify(pubkey); err != nil {
+				log.Warnf("Ignoring %s, %v", o.Key, err)
+				continue
+			}
+		} else if len(m.Recipients) > 0 && !encryptedFor(m, a.Signer) {
+			log.Warnf("Ignoring %s, it is signed by %s and the share was encrypted for %s.", o.Key, a.Signer, strings.Join(m.Recipients, ", "))
+			continue
+		} else {
+			unchecked++
+		}
+		if !a.For(folder, "") {
+			log.Warnf("Ignoring %s, it acknowledges %s, not %s.", o.Key, a.Folder, folder)
+			continue
+		}
+		if !a.For(folder, digest) {
+			log.Infof("%s is for an earlier version of the manifest.", o.Key)
+		}
+		acks = append(acks, a)
+	}
+	if unchecked > 0 {
+		log.Warnf("No --receiver-public-key was given, the signatures of %d acknowledgements were not checked.", unchecked)
+	}
+	return acks, nil
+}
+
+// deliveredNames are the names of the files of a share the receiver
+// acknowledges.
+func deliveredNames(m manifest.Manifest) []string {
+	var names []string
+	for _, f := range m.Files {
+		if !strings.HasSuffix(f.Name, "manifest.json") {
+			names = append(names, manifest.CleanName(f.Name))
+		}
+	}
+	return names
+}
+
+// encryptedFor reports whether the share was encrypted for a key.
+func encryptedFor(m manifest.Manifest, fingerprint string) bool {
+	for _, r := range m.Recipients {
+		if r == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func printDelivery(share string, d ack.Delivery, acks []ack.Ack, files int) {
+	fmt.Printf("Share %s is %s, %d of %d files.\n", share, d.Status, len(d.Delivered), files)
+	if len(acks) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RECEIVED\tRECEIVER\tSIGNER\tVERIFIED\tRECEIVED ONLY\tFAILED")
+		for _, a := range acks {
+			counts := map[string]int{}
+			for _, f := range a.Files {
+				counts[f.Status]++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", a.Received.Local().Format("2006-01-02 15:04"), a.Receiver, a.Signer,
+				counts[ack.Verified], counts[ack.Received], counts[ack.Failed])
+		}
+		w.Flush()
+	}
+	for _, name := range d.Failed {
+		fmt.Printf("FAIL\t%s\n", name)
+	}
+	for _, name := range d.Missing {
+		fmt.Printf("MISSING\t%s\n", name)
+	}
+}
+
+// checkReceived hashes each decrypted file against the manifest, failing
+// the ones that do not match, and records how every file turned out.
+func checkReceived(files []manifest.FileDescription, errs []error, opts options.Options) []ack.File {
+	received := make([]ack.File, len(files))
+	for i, f := range files {
+		name := manifest.CleanName(f.Name)
+		received[i] = ack.File{Name: name, Status: ack.Failed}
+		if errs[i] != nil {
+			continue
+		}
+		fn := filepath.Join(opts.Destination, f.Name)
+		if _, err := os.Stat(fn); err != nil {
+			errs[i] = fmt.Errorf("%s was not decrypted", name)
+		} else if !manifest.Hashed(f) {
+			received[i].Status = ack.Received
+		} else if manifest.HashFile(fn) != f.Hash {
+			errs[i] = fmt.Errorf("%s does not match the manifest hash", name)
+		} else {
+			received[i].Status = ack.Verified
+		}
+	}
+	return received
+}
+
+// acknowledge writes a signed ack of the files received into the share
+// folder for the sender to check with status.  Not being able to write
+// it does not fail the decrypt.
+func acknowledge(store storage.Storage, folder string, m manifest.Manifest, received []ack.File, opts options.Options) {
+	if opts.URL != "" {
+		log.Debug("Shares decrypted through a link are not acknowledged.")
+		return
+	}
+	if opts.PubKey == "" || opts.PrivKey == "" {
+		log.Warn("No acknowledgement was written, it needs --my-public-key and --my-private-key to sign it.")
+		return
+	}
+	a := ack.Ack{
+		Folder:         folder,
+		ManifestDigest: manifest.Digest(m),
+		Receiver:       audit.Actor(),
+		Received:       time.Now().UTC(),
+		Files:          received,
+	}
+	if err := a.Sign(opts.PubKey, opts.PrivKey); err != nil {
+		log.Warnf("Unable to sign the acknowledgement, %v", err)
+		return
+	}
+	data, _ := json.MarshalIndent(a, "", "  ")
+	if _, err := storage.WriteObject(store, a.Key(), data, storage.PutOptions{}); err != nil {
+		log.Warnf("Unable to write the acknowledgement %s, %v", a.Key(), err)
+		return
+	}
+	log.Infof("Acknowledged the share at %s.", a.Key())
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().String("share", "", "The share to check, its id or manifest.")
+	statusCmd.Flags().String("receiver-public-key", "", "The receiver's public key, to check the acknowledgements with.")
+	statusCmd.Flags().String("format", "table", "The output format: table or json.")
+}
@@ -0,0 +1,205 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	resume "github.com/jemurai/s3s2/resume"
+	s3helper "github.com/jemurai/s3s2/s3"
+	storage "github.com/jemurai/s3s2/storage"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// openStorage picks where shares are kept: a local directory when
+// --local-store is given and the S3 bucket otherwise.
+func openStorage(options options.Options) storage.Storage {
+	if options.LocalStore != "" {
+		return storage.NewLocal(options.LocalStore)
+	}
+	store, err := s3helper.NewStore(withConnection(options))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+// hasStorage reports whether the options say where shares are kept.
+// A custom endpoint does not need a region.
+func hasStorage(options options.Options) bool {
+	options = withConnection(options)
+	return options.LocalStore != "" ||
+		(options.Bucket != "" && (options.Region != "" || options.Endpoint != ""))
+}
+
+// withConnection fills in the connection and transfer settings, which
+// are global flags rather than per command options.
+func withConnection(options options.Options) options.Options {
+	if options.Endpoint == "" {
+		options.Endpoint = viper.GetString("endpoint")
+	}
+	if options.CABundle == "" {
+		options.CABundle = viper.GetString("ca-bundle")
+	}
+	if options.SSE == "" {
+		options.SSE = viper.GetString("sse")
+	}
+	if options.SSECustomerKeyFile == "" {
+		options.SSECustomerKeyFile = viper.GetString("sse-customer-key-file")
+	}
+	if options.StorageClass == "" {
+		options.StorageClass = viper.GetString("storage-class")
+	}
+	if options.StateDir == "" {
+		options.StateDir = viper.GetString("state-dir")
+	}
+	options.PathStyle = options.PathStyle || viper.GetBool("path-style")
+	options.Insecure = options.Insecure || viper.GetBool("insecure-skip-verify")
+	options.NoChecksums = options.NoChecksums || viper.GetBool("no-checksums")
+	if options.PartSize == 0 {
+		options.PartSize = viper.GetInt64("part-size")
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = viper.GetInt("concurrency")
+	}
+	if options.MaxConnections == 0 {
+		options.MaxConnections = viper.GetInt("max-connections")
+	}
+	if options.MaxBandwidth == "" {
+		options.MaxBandwidth = viper.GetString("max-bandwidth")
+	}
+	if len(options.TransferWindows) == 0 {
+		options.TransferWindows = viper.GetStringSlice("transfer-window")
+	}
+	return options
+}
+
+// inWindow runs a transfer in the transfer windows.  The stores stop a
+// transfer the window closes on, and it runs again once the next window
+// opens, without counting as a failed attempt.
+func inWindow(options options.Options, transfer func() error) error {
+	limiter, err := s3helper.Limiter(withConnection(options))
+	if err != nil {
+		return err
+	}
+	for {
+		limiter.Pause()
+		err := transfer()
+		if err == nil || limiter.Open() {
+			return err
+		}
+		log.Infof("The transfer window closed, carrying on when it opens again: %v", err)
+	}
+}
+
+// checkTransferOptions refuses a bandwidth or transfer window that
+// does not parse, before anything is transferred.
+func checkTransferOptions(options options.Options) {
+	if _, err := s3helper.Limiter(withConnection(options)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serverSideEncryption is the SSE mode a share is uploaded with and,
+// for SSE-C, the MD5 that identifies the key.
+func serverSideEncryption(options options.Options) (string, string) {
+	mode := s3helper.ServerSideEncryption(options)
+	if mode != s3helper.SSECustomer {
+		return mode, ""
+	}
+	key, err := s3helper.CustomerKey(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if key == nil {
+		log.Fatalf("--sse sse-c needs --sse-customer-key-file or $%s.", s3helper.CustomerKeyEnv)
+	}
+	return mode, s3helper.CustomerKeyMD5(key)
+}
+
+// objectKey is the key a local file, or the file prepared from it in
+// the work directory, is uploaded to in a share folder.
+func objectKey(folder string, filename string, options options.Options) string {
+	if options.WorkDir != "" && strings.HasPrefix(filename, options.WorkDir) {
+		return filepath.Clean(folder + "/" + strings.TrimPrefix(filename, options.WorkDir))
+	}
+	return filepath.Clean(folder + "/" + strings.Replace(filename, options.Directory, "", -1))
+}
+
+// shareWorkDir is the directory of its own a share is prepared in,
+// under --work-dir.  Every share has its own, so an interrupted upload
+// is only ever resumed into the share it was started for.
+func shareWorkDir(options options.Options, folder string) string {
+	dir := options.WorkDir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(resume.DefaultDir()), "work")
+	}
+	return filepath.Join(dir, folder)
+}
+
+// downloadFile fetches an object into a directory, at the same
+// relative path as its key.  Keys come from manifests, which may not
+// be trusted, so a key that would land outside the directory is refused.
+func downloadFile(store storage.Storage, directory string, key string) (string, error) {
+	filename, err := inDirectory(directory, key)
+	if err != nil {
+		return "", fmt.Errorf("refusing to download %s, %v", key, err)
+	}
+	return filename, storage.GetFile(store, key, filename)
+}
+
+// inDirectory joins a key or folder from a manifest onto a local
+// directory.  Manifests may come from anyone with a link, so names that
+// are absolute, climb out with .. or name the directory itself are
+// refused.
+func inDirectory(directory string, name string) (string, error) {
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s is an absolute path", name)
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("%s leaves %s", name, directory)
+		}
+	}
+	joined := filepath.Join(directory, filepath.FromSlash(name))
+	rel, err := filepath.Rel(filepath.Clean(directory), joined)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside %s", name, directory)
+	}
+	return joined, nil
+}
+
+// checkDownload compares a downloaded object with the checksum it was
+// uploaded with, so a corrupt download is caught, and retried, before
+// it is decrypted.  Files from older manifests have no checksum.
+func checkDownload(filename string, f manifest.FileDescription) error {
+	if f.Checksum == "" {
+		return nil
+	}
+	checksum, err := storage.Checksum(filename, f.PartSize)
+	if err != nil {
+		return err
+	}
+	if checksum != f.Checksum {
+		return fmt.Errorf("download of %s has checksum %s, not %s", filename, checksum, f.Checksum)
+	}
+	return nil
+}
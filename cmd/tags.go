@@ -0,0 +1,97 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"unicode"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// Names of the tags and metadata s3s2 puts on the objects it writes.
+const (
+	tagOrg            = "org"
+	tagShare          = "share"
+	tagClassification = "classification"
+	tagExpires        = "expires"
+
+	metaVersion        = "s3s2-version"
+	metaManifestDigest = "s3s2-manifest-digest"
+	metaShareDigest    = "s3s2-share-digest"
+)
+
+// maxTagValue is the longest tag value S3 accepts.
+const maxTagValue = 256
+
+// objectTags are the tags on an object of a share, so bucket lifecycle
+// rules and cost reports can tell shares apart.
+func objectTags(m manifest.Manifest, f manifest.FileDescription) map[string]string {
+	tags := map[string]string{
+		tagOrg:   tagValue(m.Organization),
+		tagShare: tagValue(m.Folder),
+	}
+	for name, value := range labelTags(m, f) {
+		tags[name] = tagValue(value)
+	}
+	if m.Expires != nil {
+		tags[tagExpires] = m.Expires.UTC().Format("2006-01-02")
+	}
+	return tags
+}
+
+// fileOptions are the tags and metadata for the object of a file.  It
+// carries the share digest of the manifest, so verify can tell which
+// manifest the object was uploaded for.
+func fileOptions(m manifest.Manifest, f manifest.FileDescription) storage.PutOptions {
+	return storage.PutOptions{
+		Tags: objectTags(m, f),
+		Metadata: map[string]string{
+			metaVersion:     Version,
+			metaShareDigest: manifest.ShareDigest(m),
+		},
+	}
+}
+
+// manifestOptions are the tags and metadata for the manifest object.
+// It carries the digest the manifest was uploaded with and its share
+// digest.  Anyone who can write the object can rewrite its metadata
+// too, only the signature proves who made the manifest.
+func manifestOptions(m manifest.Manifest) storage.PutOptions {
+	return storage.PutOptions{
+		Tags: objectTags(m, manifest.FileDescription{}),
+		Metadata: map[string]string{
+			metaVersion:        Version,
+			metaManifestDigest: manifest.Digest(m),
+			metaShareDigest:    manifest.ShareDigest(m),
+		},
+	}
+}
+
+// tagValue replaces the characters S3 does not allow in a tag value
+// and cuts it to the longest value allowed.
+func tagValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || strings.ContainsRune("_.:/=+-@", r) {
+			return r
+		}
+		return '_'
+	}, value)
+	if runes := []rune(value); len(runes) > maxTagValue {
+		value = string(runes[:maxTagValue])
+	}
+	return value
+}
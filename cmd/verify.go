@@ -0,0 +1,295 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	audit "github.com/jemurai/s3s2/audit"
+	encrypt "github.com/jemurai/s3s2/encrypt"
+	manifest "github.com/jemurai/s3s2/manifest"
+	options "github.com/jemurai/s3s2/options"
+	storage "github.com/jemurai/s3s2/storage"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify a share's manifest, signature and files",
+	Long: `Verify a share's manifest, signature and files.
+
+The Merkle root of the manifest is recomputed from the file hashes and
+the sender's signature is checked.  The signature covers the whole
+manifest, the root, recipients, encryption settings and labels
+included, not only the root.  With --local, files that were already
+decrypted are hashed and compared to the manifest.  With --prove, an
+inclusion proof for a single file is printed that anyone holding the
+signed manifest can check with --proof, without the other files.
+With --objects, the share's objects in the bucket are checked for
+their tags, share digests and SHA-256 checksums, and the manifest
+against the digest it was uploaded with.  The digests in the metadata
+catch mistakes, such as objects left from another share, but anyone who
+can write the bucket can rewrite them.  Only the signature proves who
+made the manifest.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		local, _ := cmd.Flags().GetString("local")
+		prove, _ := cmd.Flags().GetString("prove")
+		proofFile, _ := cmd.Flags().GetString("proof")
+		objects, _ := cmd.Flags().GetBool("objects")
+		opts := options.Options{
+			Bucket:       viper.GetString("bucket"),
+			Region:       viper.GetString("region"),
+			LocalStore:   viper.GetString("local-store"),
+			SenderPubKey: flagOrConfig(cmd, "sender-public-key"),
+		}
+		if file == "" {
+			log.Fatal("Need to supply a manifest with --file.")
+		}
+
+		m, err := loadManifest(file, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if prove != "" {
+			proof, err := manifest.BuildProof(m.Files, prove)
+			if err != nil {
+				log.Fatal(err)
+			}
+			data, _ := json.MarshalIndent(proof, "", " ")
+			fmt.Println(string(data))
+			return
+		}
+
+		r := &report{}
+		verifyManifest(r, m, opts)
+		if proofFile != "" {
+			verifyProofFile(r, m, proofFile)
+		}
+		if local != "" {
+			verifyLocal(r, m, local)
+		}
+		if objects {
+			if !hasStorage(opts) {
+				log.Fatal("Need to supply a bucket and region to check the objects.")
+			}
+			verifyObjects(r, openStorage(opts), m, withWorkers(opts))
+		}
+		r.summary()
+		outcome := audit.Success
+		if r.failures > 0 {
+			outcome = audit.Failure
+		}
+		recordAudit(audit.Entry{
+			Action:         audit.Verify,
+			ShareID:        m.Folder,
+			ManifestDigest: manifest.Digest(m),
+			Outcome:        outcome,
+			Detail:         fmt.Sprintf("%d failed, %d warnings", r.failures, r.warnings),
+		}, opts)
+		if r.failures > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// checkManifest recomputes the Merkle root and checks the sender's
+// signature, which covers the SignedDigest of the whole manifest, against
+// the sender's public key.
+func checkManifest(m manifest.Manifest, pubkey string) error {
+	if m.Root == "" {
+		return fmt.Errorf("manifest %s has no Merkle root", m.Folder)
+	}
+	if root := manifest.MerkleRoot(m.Files); root != m.Root {
+		return fmt.Errorf("manifest %s root %s does not match its files (%s)", m.Folder, m.Root, root)
+	}
+	if m.Signature == "" {
+		return fmt.Errorf("manifest %s is not signed", m.Folder)
+	}
+	if err := encrypt.Verify([]byte(manifest.SignedDigest(m)), m.Signature, pubkey); err != nil {
+		return fmt.Errorf("manifest %s signature is not valid, %v", m.Folder, err)
+	}
+	return nil
+}
+
+func verifyManifest(r *report, m manifest.Manifest, opts options.Options) {
+	if m.Root == "" {
+		r.warn("Manifest has no Merkle root, the files were not hashed.")
+		return
+	}
+	if root := manifest.MerkleRoot(m.Files); root != m.Root {
+		r.fail("Merkle root %s does not match the files in the manifest (%s).", m.Root, root)
+		return
+	}
+	r.pass("Merkle root %s matches the %d files in the manifest.", m.Root, len(m.Files))
+
+	if m.Signature == "" {
+		r.warn("Manifest is not signed.")
+	} else if opts.SenderPubKey == "" {
+		r.warn("Manifest is signed by %s but no --sender-public-key was given to check it.", m.Signer)
+	} else if err := checkManifest(m, opts.SenderPubKey); err != nil {
+		r.fail("%v", err)
+	} else {
+		r.pass("Signature by %s is valid.", m.Signer)
+	}
+}
+
+func verifyProofFile(r *report, m manifest.Manifest, proofFile string) {
+	var proof manifest.Proof
+	data, err := ioutil.ReadFile(proofFile)
+	if err == nil {
+		err = json.Unmarshal(data, &proof)
+	}
+	if err != nil {
+		r.fail("Unable to read proof %s, %v", proofFile, err)
+	} else if proof.Root != m.Root || !manifest.VerifyProof(m.Root, proof) {
+		r.fail("Proof for %s does not lead to root %s.", proof.Name, m.Root)
+	} else {
+		r.pass("%s with hash %s is included in the share.", proof.Name, proof.Hash)
+	}
+}
+
+func verifyLocal(r *report, m manifest.Manifest, directory string) {
+	local, _, err := manifest.ScanDirectory(options.Options{Directory: directory, Hash: true})
+	if err != nil {
+		r.fail("Unable to read %s, %v", directory, err)
+		return
+	}
+	hashes := make(map[string]string)
+	for _, f := range local {
+		hashes[manifest.CleanName(f.Name)] = f.Hash
+	}
+	for _, f := range m.Files {
+		name := manifest.CleanName(f.Name)
+		hash, ok := hashes[name]
+		if !ok {
+			r.fail("%s is missing.", name)
+		} else if !manifest.Hashed(f) {
+			r.warn("%s is present but was not hashed by the sender.", name)
+		} else if hash != f.Hash {
+			r.fail("%s does not match the manifest hash.", name)
+		} else {
+			r.pass("%s matches.", name)
+		}
+	}
+}
+
+// verifyObjects checks the objects of a share in the store: that each
+// one is there, tagged with its share, uploaded for this manifest and
+// has the checksum recorded when it was uploaded, and that the manifest
+// matches the digest it was uploaded with.
+func verifyObjects(r *report, store storage.Storage, m manifest.Manifest, opts options.Options) {
+	key := manifestKey(m.Folder)
+	details, err := storage.Describe(store, key)
+	if err == storage.ErrNoDetails {
+		r.warn("The store keeps no tags or metadata, the objects were not checked.")
+		return
+	} else if err != nil {
+		r.fail("Unable to read the manifest object %s, %v", key, err)
+		return
+	}
+	switch digest := details.Metadata[metaManifestDigest]; digest {
+	case "":
+		r.warn("Manifest object has no digest in its metadata, it was uploaded by an older s3s2.")
+	case manifest.Digest(m):
+		r.pass("Manifest matches the digest it was uploaded with.")
+	default:
+		r.fail("Manifest does not match the digest %s it was uploaded with.", digest)
+	}
+
+	pending := map[string]bool{}
+	for _, name := range m.Pending {
+		pending[name] = true
+	}
+	var files []manifest.FileDescription
+	for _, f := range m.Files {
+		if !pending[f.Name] && !strings.HasSuffix(f.Name, "manifest.json") {
+			files = append(files, f)
+		}
+	}
+	described := make([]storage.Details, len(files))
+	missing := make([]bool, len(files))
+	errs := runFiles(opts, len(files), ioStage(opts, func(i int) error {
+		key := m.ObjectKey(files[i])
+		return retryPolicy(opts).Do("Reading "+key, func() error {
+			var err error
+			described[i], err = storage.Describe(store, key)
+			if err == storage.ErrNotFound {
+				missing[i] = true
+				return nil
+			}
+			return err
+		})
+	}))
+	for i, f := range files {
+		key := m.ObjectKey(f)
+		share := described[i].Tags[tagShare]
+		switch {
+		case missing[i]:
+			r.fail("%s is missing from the store.", key)
+		case errs[i] != nil:
+			r.fail("Unable to read %s, %v", key, errs[i])
+		case share == "":
+			r.warn("%s is not tagged, it was uploaded by an older s3s2.", key)
+		case share != tagValue(m.ObjectFolder(f)):
+			r.fail("%s is tagged with share %s, not %s.", key, share, m.ObjectFolder(f))
+		default:
+			r.pass("%s is in the store, tagged %s.", key, formatTags(described[i].Tags))
+		}
+		if missing[i] || errs[i] != nil {
+			continue
+		}
+		if m.ObjectFolder(f) == m.Folder {
+			switch digest := described[i].Metadata[metaShareDigest]; digest {
+			case "":
+				r.warn("%s has no share digest in its metadata, it was uploaded by an older s3s2.", key)
+			case manifest.ShareDigest(m):
+				r.pass("%s was uploaded for this manifest.", key)
+			default:
+				r.fail("%s was uploaded for another manifest, with share digest %s.", key, digest)
+			}
+		}
+		switch checksum := described[i].Checksum; {
+		case f.Checksum == "":
+			r.warn("%s has no checksum in the manifest, it was shared by an older s3s2.", key)
+		case checksum == "":
+			r.warn("The store has no checksum for %s.", key)
+		case checksum != f.Checksum:
+			r.fail("%s has checksum %s, not %s as it was uploaded.", key, checksum, f.Checksum)
+		default:
+			r.pass("%s matches its checksum %s.", key, checksum)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().String("file", "", "The manifest to verify.  A local file or the key in the bucket.")
+	verifyCmd.Flags().String("sender-public-key", "", "The sender's public key to check the manifest signature.  A local file path.")
+	verifyCmd.Flags().String("local", "", "A local directory of decrypted files to check against the manifest.")
+	verifyCmd.Flags().String("prove", "", "Print the inclusion proof for the named file.")
+	verifyCmd.Flags().String("proof", "", "Check an inclusion proof file against the manifest.")
+	verifyCmd.Flags().Bool("objects", false, "Check that the share's objects are in the bucket, tagged with the share, and that the manifest is the one uploaded.")
+}
@@ -0,0 +1,88 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"time"
+
+	options "github.com/jemurai/s3s2/options"
+	pipeline "github.com/jemurai/s3s2/pipeline"
+	retry "github.com/jemurai/s3s2/retry"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// withWorkers fills in how many files are worked on at once and how
+// often they are retried.
+func withWorkers(options options.Options) options.Options {
+	if options.Parallel == 0 {
+		options.Parallel = viper.GetInt("parallel")
+	}
+	if options.CPUWorkers == 0 {
+		options.CPUWorkers = viper.GetInt("cpu-workers")
+	}
+	if options.IOWorkers == 0 {
+		options.IOWorkers = viper.GetInt("io-workers")
+	}
+	if options.Retries == 0 {
+		options.Retries = viper.GetInt("retries")
+	}
+	if options.RetryWait == 0 {
+		options.RetryWait = viper.GetDuration("retry-wait")
+	}
+	return options
+}
+
+// retryPolicy is how transfers are retried.
+func retryPolicy(options options.Options) retry.Policy {
+	return retry.Policy{
+		Retries: options.Retries,
+		Wait:    options.RetryWait,
+		MaxWait: time.Minute,
+	}
+}
+
+// cpuStage compresses, encrypts or decrypts files.
+func cpuStage(options options.Options, do func(i int) error) pipeline.Stage {
+	return pipeline.Stage{Workers: options.CPUWorkers, Do: do}
+}
+
+// ioStage uploads or downloads files.
+func ioStage(options options.Options, do func(i int) error) pipeline.Stage {
+	return pipeline.Stage{Workers: options.IOWorkers, Do: do}
+}
+
+// runFiles sends n files through the stages.  A file that fails does
+// not stop the others; the error for each file is returned.
+func runFiles(options options.Options, n int, stages ...pipeline.Stage) []error {
+	p := pipeline.Pipeline{Parallel: options.Parallel, Stages: stages}
+	return p.Run(n)
+}
+
+// summarize reports the files that failed and returns how many did.
+func summarize(names []string, errs []error) int {
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			log.Errorf("%s failed, %v", names[i], err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Errorf("%d of %d files failed.", failed, len(errs))
+	}
+	return failed
+}
@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/manifest"
+)
+
+var _ = Describe("Diff", func() {
+	var (
+		now  time.Time
+		from []manifest.FileDescription
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		from = []manifest.FileDescription{
+			{Name: "/same.csv", Size: 10, Modified: now, Hash: "aaa"},
+			{Name: "/changed.csv", Size: 10, Modified: now, Hash: "bbb"},
+			{Name: "/gone.csv", Size: 10, Modified: now, Hash: "ccc"},
+			{Name: "/old-name.csv", Size: 10, Modified: now, Hash: "ddd"},
+		}
+	})
+
+	It("should classify added, removed, modified and renamed files", func() {
+		to := []manifest.FileDescription{
+			{Name: "same.csv", Size: 10, Modified: now, Hash: "aaa"},
+			{Name: "changed.csv", Size: 10, Modified: now, Hash: "eee"},
+			{Name: "new-name.csv", Size: 10, Modified: now, Hash: "ddd"},
+			{Name: "new.csv", Size: 10, Modified: now, Hash: "fff"},
+		}
+		d := manifest.DiffFiles(from, to)
+		Expect(d.Unchanged).To(HaveLen(1))
+		Expect(d.Modified[0].Name).To(Equal("changed.csv"))
+		Expect(d.Removed[0].Name).To(Equal("/gone.csv"))
+		Expect(d.Added[0].Name).To(Equal("new.csv"))
+		Expect(d.Renamed[0].From.Name).To(Equal("/old-name.csv"))
+		Expect(d.Renamed[0].To.Name).To(Equal("new-name.csv"))
+	})
+
+	It("should fall back to size and time without hashes", func() {
+		to := []manifest.FileDescription{
+			{Name: "/same.csv", Size: 10, Modified: now, Hash: manifest.FakeHash},
+			{Name: "/changed.csv", Size: 11, Modified: now, Hash: manifest.FakeHash},
+		}
+		d := manifest.DiffFiles(from[:2], to)
+		Expect(d.Unchanged).To(HaveLen(1))
+		Expect(d.Modified).To(HaveLen(1))
+		Expect(d.Changed()).To(BeTrue())
+	})
+
+	Describe("Incremental shares", func() {
+		It("should only upload changed files and refer to earlier shares", func() {
+			previous := manifest.Manifest{Folder: "second", Files: []manifest.FileDescription{
+				{Name: "/same.csv", Size: 10, Modified: now, Hash: "aaa", Folder: "first", Checksum: "ccc-2", PartSize: 8},
+				{Name: "/changed.csv", Size: 10, Modified: now, Hash: "bbb"},
+			}}
+			m := manifest.Manifest{Folder: "third", Files: []manifest.FileDescription{
+				{Name: "/same.csv", Size: 10, Modified: now, Hash: "aaa"},
+				{Name: "/changed.csv", Size: 10, Modified: now, Hash: "eee"},
+			}}
+			upload := m.BuildOn(previous)
+			Expect(upload).To(HaveLen(1))
+			Expect(upload[0].Name).To(Equal("/changed.csv"))
+			Expect(m.ObjectFolder(m.Files[0])).To(Equal("first"))
+			Expect(m.Files[0].Checksum).To(Equal("ccc-2"))
+			Expect(m.Files[0].PartSize).To(Equal(int64(8)))
+			Expect(m.ObjectFolder(m.Files[1])).To(Equal("third"))
+			Expect(m.Since).To(Equal("second"))
+		})
+	})
+})
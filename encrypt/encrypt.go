@@ -15,10 +15,12 @@
 package encrypt
 
 import (
+	"bytes"
 	"compress/gzip"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -57,14 +59,53 @@ import (
 // https://github.com/keybase/saltpack
 // https://github.com/hashicorp/vault/blob/master/command/pgp_test.go
 
-// Decrypt a file with a provided key.
-func Decrypt(filename string, pubkey string, privkey string) {
-	decryptFile(pubkey, privkey, filename)
+// Decrypt a file with a provided key.  The decrypted file is written
+// next to it, without the .gpg.
+func Decrypt(filename string, pubkey string, privkey string) error {
+	return decryptFile(pubkey, privkey, filename)
 }
 
-// Encrypt a file
-func Encrypt(filename string, pubkey string) {
-	encryptFile(pubkey, filename)
+// Encrypt a file for a public key.  The encrypted file is written next
+// to it, with .gpg added.
+func Encrypt(filename string, pubkey string) error {
+	return encryptFile(pubkey, filename)
+}
+
+// Sign makes an armored detached signature of data with the signer's keys.
+func Sign(data []byte, pubkey string, privkey string) (string, error) {
+	pubKey := decodePublicKey(pubkey)
+	privKey := decodePrivateKey(privkey)
+	if pubKey == nil || privKey == nil {
+		return "", errors.New("unable to read the signing keys")
+	}
+	signer := createEntityFromKeys(pubKey, privKey)
+	config := getEncryptionConfig()
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer, bytes.NewReader(data), &config); err != nil {
+		return "", err
+	}
+	return signature.String(), nil
+}
+
+// Verify checks an armored detached signature of data against a public key.
+func Verify(data []byte, signature string, pubkey string) error {
+	pubKey := decodePublicKey(pubkey)
+	if pubKey == nil {
+		return errors.New("unable to read the public key")
+	}
+	keyring := openpgp.EntityList{createEntityFromKeys(pubKey, nil)}
+	_, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), strings.NewReader(signature))
+	return err
+}
+
+// Fingerprint of a public key file, as upper case hex.
+func Fingerprint(pubkey string) (string, error) {
+	pubKey := decodePublicKey(pubkey)
+	if pubKey == nil {
+		return "", errors.New("unable to read the public key")
+	}
+	return strings.ToUpper(hex.EncodeToString(pubKey.Fingerprint[:])), nil
 }
 
 // This was an older deprecated function.
@@ -154,12 +195,14 @@ func decodePrivateKey(filename string) *packet.PrivateKey {
 	in, err := os.Open(filename)
 	if err != nil {
 		log.Error(err)
+		return nil
 	}
 	defer in.Close()
 
 	block, err := armor.Decode(in)
 	if err != nil {
 		log.Error(err)
+		return nil
 	}
 
 	if block.Type != openpgp.PrivateKeyType {
@@ -185,12 +228,14 @@ func decodePublicKey(filename string) *packet.PublicKey {
 	in, err := os.Open(filename)
 	if err != nil {
 		log.Error(err)
+		return nil
 	}
 	defer in.Close()
 
 	block, err := armor.Decode(in)
 	if err != nil {
 		log.Error(err)
+		return nil
 	}
 
 	if block.Type != openpgp.PublicKeyType {
@@ -274,69 +319,79 @@ func createEntityFromKeys(pubKey *packet.PublicKey, privKey *packet.PrivateKey)
 	return &e
 }
 
-func encryptFile(publicKey string, file string) {
+func encryptFile(publicKey string, file string) error {
 	pubKey := decodePublicKey(publicKey)
+	if pubKey == nil {
+		return fmt.Errorf("unable to read the public key to encrypt %s", file)
+	}
 	config := getEncryptionConfig()
 	//	privKey := decodePrivateKey(privateKey)
 	to := createEntityFromKeys(pubKey, nil) // We shouldn't have the receiver's private key!!!
 
+	infile, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+
 	ofile, err := os.Create(file + ".gpg")
 	if err != nil {
-		log.Error(err)
+		return err
 	}
 	defer ofile.Close()
 
 	w, err := armor.Encode(ofile, "Message", make(map[string]string))
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to encrypt %s, %v", file, err)
 	}
-	defer w.Close()
 
 	// Here the signer should be the sender
 	plain, err := openpgp.Encrypt(w, []*openpgp.Entity{to}, nil, &openpgp.FileHints{IsBinary: true}, &config)
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to encrypt %s, %v", file, err)
 	}
-	defer plain.Close()
 
 	compressed, err := gzip.NewWriterLevel(plain, gzip.BestCompression) //BestCompression)
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to encrypt %s, %v", file, err)
 	}
 
-	infile, err := os.Open(file)
-	if err != nil {
-		log.Error(err)
-	}
-	defer infile.Close()
-
 	n, err := io.Copy(compressed, infile)
 	if err != nil {
-		log.Errorf("Error writing encrypted file %d", n)
+		return fmt.Errorf("unable to encrypt %s after %d bytes, %v", file, n, err)
 	}
 
-	compressed.Close()
+	// Each layer flushes into the one below it as it is closed.
+	for _, c := range []io.Closer{compressed, plain, w, ofile} {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("unable to encrypt %s, %v", file, err)
+		}
+	}
+	return nil
 }
 
-func decryptFile(publicKey string, privateKey string, file string) {
+func decryptFile(publicKey string, privateKey string, file string) error {
 	pubKey := decodePublicKey(publicKey)
 	privKey := decodePrivateKey(privateKey)
+	if pubKey == nil || privKey == nil {
+		return fmt.Errorf("unable to read the keys to decrypt %s", file)
+	}
 
 	entity := createEntityFromKeys(pubKey, privKey)
 
 	in, err := os.Open(file)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
 	defer in.Close()
 
 	block, err := armor.Decode(in)
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to decrypt %s, %v", file, err)
 	}
 
 	if block.Type != "Message" {
-		log.Error("Invalid message type")
+		return fmt.Errorf("unable to decrypt %s, invalid message type %s", file, block.Type)
 	}
 
 	var entityList openpgp.EntityList
@@ -345,30 +400,26 @@ func decryptFile(publicKey string, privateKey string, file string) {
 	config := getEncryptionConfig()
 	md, err := openpgp.ReadMessage(block.Body, entityList, nil, &config)
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to decrypt %s, %v", file, err)
 	}
 
 	compressed, err := gzip.NewReader(md.UnverifiedBody)
 	if err != nil {
-		log.Error(err)
+		return fmt.Errorf("unable to decrypt %s, %v", file, err)
 	}
 	defer compressed.Close()
-	if err != nil {
-		log.Error(err)
-	}
 	dfn := strings.TrimSuffix(file, ".gpg")
 	dfile, err := os.Create(dfn)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
 	defer dfile.Close()
 
 	n, err := io.Copy(dfile, compressed)
 	if err != nil {
-		log.Error(err, "Error reading encrypted file")
-		log.Errorf("Decrypted %d bytes", n)
+		return fmt.Errorf("unable to decrypt %s after %d bytes, %v", file, n, err)
 	}
-
+	return dfile.Close()
 }
 
 func signFile(publicKey string, privateKey string, file string) {
@@ -0,0 +1,208 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file we look for at the
+// root of the directory being shared.
+const FileName = ".s3s2ignore"
+
+// pattern is a single compiled gitignore style pattern.
+type pattern struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// Matcher decides which paths under a share root should be shared.
+// Exclude patterns follow .gitignore semantics: the last matching
+// pattern wins and a leading "!" re-includes a path.  If any include
+// patterns are present, a file must match one of them to be shared.
+type Matcher struct {
+	excludes []pattern
+	includes []pattern
+}
+
+// NewMatcher builds a Matcher from include and exclude patterns.
+func NewMatcher(includes []string, excludes []string) *Matcher {
+	m := &Matcher{}
+	for _, p := range includes {
+		if c, ok := compile(p); ok {
+			m.includes = append(m.includes, c)
+		}
+	}
+	m.AddExcludes(excludes)
+	return m
+}
+
+// AddExcludes appends exclude patterns to the matcher.
+func (m *Matcher) AddExcludes(excludes []string) {
+	for _, p := range excludes {
+		if c, ok := compile(p); ok {
+			m.excludes = append(m.excludes, c)
+		}
+	}
+}
+
+// ReadFile adds the exclude patterns from an ignore file.
+// A missing file is not an error.
+func (m *Matcher) ReadFile(filename string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	m.AddExcludes(lines)
+	return nil
+}
+
+// Excluded reports whether a path, relative to the share root and
+// using forward slashes, is excluded by the exclude patterns.
+func (m *Matcher) Excluded(path string, isDir bool) bool {
+	excluded := false
+	for _, p := range m.excludes {
+		if p.matches(path, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// Included reports whether a file, relative to the share root, should
+// be shared.  Directories are only checked against the excludes so
+// that we keep walking into them looking for included files.
+func (m *Matcher) Included(path string, isDir bool) bool {
+	if m.Excluded(path, isDir) {
+		return false
+	}
+	if isDir || len(m.includes) == 0 {
+		return true
+	}
+	for _, p := range m.includes {
+		if p.matches(path, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether a single gitignore style pattern matches a path
+// relative to the share root.  Negation is ignored.
+func Match(glob string, path string, isDir bool) bool {
+	p, ok := compile(glob)
+	if !ok {
+		return false
+	}
+	return p.matches(path, isDir)
+}
+
+func (p pattern) matches(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(path)
+}
+
+// compile turns a gitignore style line into a pattern.  Blank lines
+// and comments are skipped.
+func compile(line string) (pattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+
+	// A pattern with a slash in it is relative to the share root,
+	// otherwise it can match a name at any depth.
+	prefix := "^(.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				b.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
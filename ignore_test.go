@@ -0,0 +1,54 @@
+package main_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/ignore"
+)
+
+var _ = Describe("Ignore", func() {
+	var (
+		matcher *ignore.Matcher
+	)
+
+	BeforeEach(func() {
+		matcher = ignore.NewMatcher(nil, []string{"*.swp", ".DS_Store", ".git/", "/build", "logs/**/*.log", "!keep.swp"})
+	})
+
+	Describe("Exclude patterns", func() {
+		It("should exclude names at any depth", func() {
+			Expect(matcher.Included("a/b/.DS_Store", false)).To(BeFalse())
+			Expect(matcher.Included("notes.txt.swp", false)).To(BeFalse())
+		})
+
+		It("should only exclude directories with a trailing slash", func() {
+			Expect(matcher.Included("sub/.git", true)).To(BeFalse())
+			Expect(matcher.Included(".git", false)).To(BeTrue())
+		})
+
+		It("should anchor patterns with a slash to the root", func() {
+			Expect(matcher.Included("build", true)).To(BeFalse())
+			Expect(matcher.Included("src/build", true)).To(BeTrue())
+		})
+
+		It("should support double star", func() {
+			Expect(matcher.Included("logs/a/b/out.log", false)).To(BeFalse())
+			Expect(matcher.Included("logs/out.log", false)).To(BeFalse())
+		})
+
+		It("should re-include negated patterns", func() {
+			Expect(matcher.Included("keep.swp", false)).To(BeTrue())
+		})
+	})
+
+	Describe("Include patterns", func() {
+		It("should only share files matching an include", func() {
+			matcher = ignore.NewMatcher([]string{"*.csv"}, []string{"secret.csv"})
+			Expect(matcher.Included("data/a.csv", false)).To(BeTrue())
+			Expect(matcher.Included("data/a.txt", false)).To(BeFalse())
+			Expect(matcher.Included("data", true)).To(BeTrue())
+			Expect(matcher.Included("data/secret.csv", false)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,192 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inbox remembers which shares a receiver has already handled,
+// so that a watcher that restarts does not decrypt a share twice.
+package inbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Outcomes of handling a share.
+const (
+	Decrypted = "decrypted"
+	Failed    = "failed"
+	Skipped   = "skipped"
+)
+
+// Share is what happened to one share.
+type Share struct {
+	ID           string    `json:"id"`
+	Manifest     string    `json:"manifest"`
+	Organization string    `json:"organization,omitempty"`
+	Destination  string    `json:"destination,omitempty"`
+	Outcome      string    `json:"outcome"`
+	Attempts     int       `json:"attempts"`
+	Error        string    `json:"error,omitempty"`
+	Processed    time.Time `json:"processed"`
+}
+
+// State is the set of shares handled from one bucket and prefix, kept
+// in a single file.
+type State struct {
+	path   string
+	mu     sync.Mutex
+	shares map[string]*Share
+}
+
+// DefaultDir is where inbox state is kept unless told otherwise.
+func DefaultDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return filepath.Join(".s3s2", "inbox")
+	}
+	return filepath.Join(home, ".s3s2", "inbox")
+}
+
+// Open loads the state for a bucket and prefix from the directory, or
+// the default one.
+func Open(dir string, bucket string, prefix string) (*State, error) {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	sum := sha256.Sum256([]byte(bucket + "\x00" + prefix))
+	s := &State{
+		path:   filepath.Join(dir, hex.EncodeToString(sum[:])+".json"),
+		shares: map[string]*Share{},
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var shares []*Share
+	if err := json.Unmarshal(data, &shares); err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		s.shares[share.ID] = share
+	}
+	return s, nil
+}
+
+// Get returns what happened to a share, or nil if it is new.
+func (s *State) Get(id string) *Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if share, ok := s.shares[id]; ok {
+		found := *share
+		return &found
+	}
+	return nil
+}
+
+// Done reports whether a share needs no more work: it was decrypted or
+// skipped, or it has failed as many times as allowed.
+func (s *State) Done(id string, maxAttempts int) bool {
+	share := s.Get(id)
+	if share == nil {
+		return false
+	}
+	return share.Outcome != Failed || (maxAttempts > 0 && share.Attempts >= maxAttempts)
+}
+
+// Record saves what happened to a share, counting the attempts.
+func (s *State) Record(share Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.shares[share.ID]; ok {
+		share.Attempts += old.Attempts
+	}
+	if share.Processed.IsZero() {
+		share.Processed = time.Now().UTC()
+	}
+	s.shares[share.ID] = &share
+	return s.save()
+}
+
+// Shares lists every share handled so far.
+func (s *State) Shares() []Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var shares []Share
+	for _, share := range s.shares {
+		shares = append(shares, *share)
+	}
+	return shares
+}
+
+// save replaces the state file in one step so a crash never leaves half
+// of it behind.  The lock must be held.
+func (s *State) save() error {
+	var shares []*Share
+	for _, share := range s.shares {
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ID < shares[j].ID })
+	data, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".inbox-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Destination is the directory a share is decrypted into: one folder
+// per organization, then one per share.
+func Destination(root string, org string, id string) string {
+	return filepath.Join(root, safeName(org, "unknown"), safeName(id, "share"))
+}
+
+// safeName makes a name from a manifest safe to use as one path element.
+func safeName(name string, empty string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return empty
+	}
+	return name
+}
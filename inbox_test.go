@@ -0,0 +1,60 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/inbox"
+)
+
+var _ = Describe("Inbox", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-inbox")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should remember shares across restarts", func() {
+		state, err := inbox.Open(dir, "bucket", "in/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Done("share_1", 3)).To(BeFalse())
+		Expect(state.Record(inbox.Share{ID: "share_1", Outcome: inbox.Decrypted, Attempts: 1})).To(Succeed())
+
+		state, err = inbox.Open(dir, "bucket", "in/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Done("share_1", 3)).To(BeTrue())
+		Expect(state.Shares()).To(HaveLen(1))
+
+		other, _ := inbox.Open(dir, "bucket", "out/")
+		Expect(other.Done("share_1", 3)).To(BeFalse())
+	})
+
+	It("should retry a failed share until the attempts run out", func() {
+		state, _ := inbox.Open(dir, "bucket", "")
+		failure := inbox.Share{ID: "share_1", Outcome: inbox.Failed, Attempts: 1, Error: "boom"}
+		state.Record(failure)
+		Expect(state.Done("share_1", 2)).To(BeFalse())
+		state.Record(failure)
+		Expect(state.Get("share_1").Attempts).To(Equal(2))
+		Expect(state.Done("share_1", 2)).To(BeTrue())
+		Expect(state.Done("share_1", 0)).To(BeFalse())
+
+		state.Record(inbox.Share{ID: "share_1", Outcome: inbox.Decrypted, Attempts: 1})
+		Expect(state.Get("share_1").Attempts).To(Equal(3))
+		Expect(state.Done("share_1", 0)).To(BeTrue())
+	})
+
+	It("should decrypt each share into its own folder", func() {
+		Expect(inbox.Destination("/in", "Acme", "acme_s3s2_1")).To(Equal(filepath.Join("/in", "Acme", "acme_s3s2_1")))
+		Expect(inbox.Destination("/in", "../etc", "a/b")).To(Equal(filepath.Join("/in", ".._etc", "a_b")))
+		Expect(inbox.Destination("/in", "", "..")).To(Equal(filepath.Join("/in", "unknown", "share")))
+	})
+})
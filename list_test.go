@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/manifest"
+)
+
+var _ = Describe("Listing shares", func() {
+	day := func(d int) time.Time {
+		return time.Date(2019, 6, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	It("should summarize a share from its manifest", func() {
+		m := manifest.Manifest{
+			Folder:       "jemurai_s3s2_abc",
+			Organization: "Jemurai",
+			User:         "mk",
+			SudoUser:     "root",
+			Timestamp:    day(1),
+			Files:        []manifest.FileDescription{{Name: "/a.csv", Size: 10}, {Name: "/b.csv", Size: 5}},
+			Pending:      []string{"/b.csv"},
+		}
+		s := manifest.Summarize("jemurai_s3s2_abc/s3s2_manifest.json", m)
+		Expect(s.ShareID).To(Equal("jemurai_s3s2_abc"))
+		Expect(s.Sender).To(Equal("root (as mk)"))
+		Expect(s.FileCount).To(Equal(2))
+		Expect(s.TotalSize).To(Equal(int64(15)))
+		Expect(s.Status()).To(Equal("incomplete (1 pending)"))
+		Expect(s.Expires).To(BeNil())
+
+		m.Folder = ""
+		m.Pending = nil
+		expires := day(30)
+		m.Expires = &expires
+		s = manifest.Summarize("in/share_1/s3s2_manifest.json", m)
+		Expect(s.ShareID).To(Equal("in/share_1"))
+		Expect(s.Status()).To(Equal("complete"))
+		Expect(*s.Expires).To(Equal(day(30)))
+	})
+
+	It("should filter by organization and date", func() {
+		s := manifest.Summary{Organization: "Jemurai", Shared: day(10)}
+		Expect(manifest.Filter{}.Matches(s)).To(BeTrue())
+		Expect(manifest.Filter{Organization: "jemurai"}.Matches(s)).To(BeTrue())
+		Expect(manifest.Filter{Organization: "Other"}.Matches(s)).To(BeFalse())
+		Expect(manifest.Filter{After: day(10)}.Matches(s)).To(BeTrue())
+		Expect(manifest.Filter{After: day(11)}.Matches(s)).To(BeFalse())
+		Expect(manifest.Filter{Before: day(10)}.Matches(s)).To(BeFalse())
+		Expect(manifest.Filter{After: day(9), Before: day(11)}.Matches(s)).To(BeTrue())
+	})
+
+	It("should sort shares", func() {
+		shares := []manifest.Summary{
+			{ShareID: "b", Shared: day(2), TotalSize: 1},
+			{ShareID: "a", Shared: day(3), TotalSize: 3},
+			{ShareID: "c", Shared: day(1), TotalSize: 2},
+		}
+		ids := func() []string {
+			var ids []string
+			for _, s := range shares {
+				ids = append(ids, s.ShareID)
+			}
+			return ids
+		}
+		Expect(manifest.SortSummaries(shares, "time", false)).To(Succeed())
+		Expect(ids()).To(Equal([]string{"c", "b", "a"}))
+		Expect(manifest.SortSummaries(shares, "time", true)).To(Succeed())
+		Expect(ids()).To(Equal([]string{"a", "b", "c"}))
+		Expect(manifest.SortSummaries(shares, "size", false)).To(Succeed())
+		Expect(ids()).To(Equal([]string{"b", "c", "a"}))
+		Expect(manifest.SortSummaries(shares, "id", false)).To(Succeed())
+		Expect(ids()).To(Equal([]string{"a", "b", "c"}))
+		Expect(manifest.SortSummaries(shares, "color", false)).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,130 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rename is a file that moved between two manifests without
+// its content changing.
+type Rename struct {
+	From FileDescription `json:"from"`
+	To   FileDescription `json:"to"`
+}
+
+// Difference describes what changed between an old and a new set of files.
+// Removed holds the old descriptions, everything else the new ones.
+type Difference struct {
+	Added     []FileDescription `json:"added"`
+	Removed   []FileDescription `json:"removed"`
+	Modified  []FileDescription `json:"modified"`
+	Renamed   []Rename          `json:"renamed"`
+	Unchanged []FileDescription `json:"unchanged"`
+}
+
+// Changed reports whether there is any difference at all.
+func (d Difference) Changed() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Modified)+len(d.Renamed) > 0
+}
+
+// Diff compares two manifests.
+func Diff(from Manifest, to Manifest) Difference {
+	return DiffFiles(from.Files, to.Files)
+}
+
+// DiffFiles compares two lists of files.  Files are compared by hash when
+// both sides were hashed and by size and modification time otherwise.
+func DiffFiles(from []FileDescription, to []FileDescription) Difference {
+	var d Difference
+	oldByName := make(map[string]FileDescription)
+	for _, f := range from {
+		oldByName[CleanName(f.Name)] = f
+	}
+	seen := make(map[string]bool)
+	var added []FileDescription
+	for _, f := range to {
+		name := CleanName(f.Name)
+		seen[name] = true
+		o, ok := oldByName[name]
+		if !ok {
+			added = append(added, f)
+		} else if SameContent(o, f) {
+			d.Unchanged = append(d.Unchanged, f)
+		} else {
+			d.Modified = append(d.Modified, f)
+		}
+	}
+	var removed []FileDescription
+	for _, f := range from {
+		if !seen[CleanName(f.Name)] {
+			removed = append(removed, f)
+		}
+	}
+
+	// Pair up removed and added files with the same content as renames.
+	used := make([]bool, len(added))
+	for _, r := range removed {
+		renamed := false
+		for i, a := range added {
+			if !used[i] && SameContent(r, a) {
+				used[i] = true
+				renamed = true
+				d.Renamed = append(d.Renamed, Rename{From: r, To: a})
+				break
+			}
+		}
+		if !renamed {
+			d.Removed = append(d.Removed, r)
+		}
+	}
+	for i, a := range added {
+		if !used[i] {
+			d.Added = append(d.Added, a)
+		}
+	}
+
+	sortFiles(d.Added)
+	sortFiles(d.Removed)
+	sortFiles(d.Modified)
+	sortFiles(d.Unchanged)
+	sort.Slice(d.Renamed, func(i, j int) bool { return d.Renamed[i].To.Name < d.Renamed[j].To.Name })
+	return d
+}
+
+// SameContent reports whether two descriptions look like the same content.
+func SameContent(a FileDescription, b FileDescription) bool {
+	if Hashed(a) && Hashed(b) {
+		return a.Hash == b.Hash
+	}
+	return a.Size == b.Size && a.Modified.Equal(b.Modified)
+}
+
+// Hashed reports whether a file description carries a real hash.
+func Hashed(f FileDescription) bool {
+	return f.Hash != "" && f.Hash != FakeHash
+}
+
+// CleanName normalizes a file name so names recorded from directories
+// with and without a trailing slash compare equal.
+func CleanName(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(name), "/")
+}
+
+func sortFiles(files []FileDescription) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+}
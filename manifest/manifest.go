@@ -27,9 +27,17 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jemurai/s3s2/ignore"
 	"github.com/jemurai/s3s2/options"
+	"github.com/jemurai/s3s2/policy"
 )
 
+// FakeHash is recorded in place of a file hash when hashing is turned off.
+const FakeHash = "fake-hash"
+
+// FileName is the name of the manifest in a share folder.
+const FileName = "s3s2_manifest.json"
+
 // FileDescription is meta info about a file we will want to
 // include in the Manifest.
 type FileDescription struct {
@@ -37,6 +45,19 @@ type FileDescription struct {
 	Size     int64
 	Modified time.Time
 	Hash     string
+	// Folder is set when the file was not uploaded with this share
+	// and instead refers to the object from an earlier share.
+	Folder string `json:",omitempty"`
+	// Key and ETag of the object in the bucket once it was uploaded.
+	Key  string `json:",omitempty"`
+	ETag string `json:",omitempty"`
+	// Checksum is the SHA-256 of the object as it was uploaded, the way
+	// S3 reports it, and PartSize the size of its parts if it was sent
+	// in parts.  Downloads must match it.
+	Checksum string `json:",omitempty"`
+	PartSize int64  `json:",omitempty"`
+	// Labels classify the file, such as PII or PHI.
+	Labels []string `json:",omitempty"`
 }
 
 // Manifest is a description of files.
@@ -49,6 +70,143 @@ type Manifest struct {
 	SudoUser     string
 	Folder       string
 	Files        []FileDescription
+	// Since is the folder of the share this one was built on, if any.
+	Since string `json:",omitempty"`
+	// Root is the Merkle root over the file hashes.  It identifies
+	// the share when the files were hashed.
+	Root string `json:",omitempty"`
+	// Signature is the sender's armored signature of the SignedDigest
+	// of the manifest and Signer is the fingerprint of the key that made it.
+	Signature string `json:",omitempty"`
+	Signer    string `json:",omitempty"`
+	// How the share was protected: the bucket it went to, the KMS key
+	// and the fingerprints of the GPG keys it was encrypted for.
+	Bucket     string   `json:",omitempty"`
+	AwsKey     string   `json:",omitempty"`
+	Recipients []string `json:",omitempty"`
+	// SSE is the server side encryption the objects were uploaded with.
+	// For SSE-C, SSEKeyMD5 identifies the key without giving it away.
+	SSE       string `json:",omitempty"`
+	SSEKeyMD5 string `json:",omitempty"`
+	// Labels classify the share as a whole.
+	Labels []string `json:",omitempty"`
+	// Expires is when the share is due to be removed, if the sender
+	// asked for it to be kept only so long.
+	Expires *time.Time `json:",omitempty"`
+	// Pending lists the files that have not been uploaded yet.  The
+	// share is complete once it is empty.
+	Pending []string `json:",omitempty"`
+	// Links are presigned URLs to download the objects of the share,
+	// by key, for receivers without credentials for the bucket.  They
+	// stop working at LinksExpire.
+	Links       map[string]string `json:",omitempty"`
+	LinksExpire *time.Time        `json:",omitempty"`
+}
+
+// AllLabels is every label on the share or on any of its files.
+func (m Manifest) AllLabels() []string {
+	sets := [][]string{m.Labels}
+	for _, f := range m.Files {
+		sets = append(sets, f.Labels)
+	}
+	return policy.Union(sets...)
+}
+
+// Uploaded records the object a file was uploaded to.
+func (m *Manifest) Uploaded(name string, key string, etag string) {
+	for i, p := range m.Pending {
+		if p == name {
+			m.Pending = append(m.Pending[:i], m.Pending[i+1:]...)
+			break
+		}
+	}
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			m.Files[i].Key = key
+			m.Files[i].ETag = etag
+			return
+		}
+	}
+}
+
+// Checksummed records the checksum of the object a file was uploaded to.
+func (m *Manifest) Checksummed(name string, checksum string, partSize int64) {
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			m.Files[i].Checksum = checksum
+			m.Files[i].PartSize = partSize
+			return
+		}
+	}
+}
+
+// Complete reports whether every file in the share was uploaded.
+func (m Manifest) Complete() bool {
+	return len(m.Pending) == 0
+}
+
+// Hashed reports whether all the files in the manifest were hashed.
+func (m Manifest) Hashed() bool {
+	for _, f := range m.Files {
+		if !Hashed(f) {
+			return false
+		}
+	}
+	return len(m.Files) > 0
+}
+
+// ObjectFolder is the folder in the bucket holding the object for a file.
+func (m Manifest) ObjectFolder(f FileDescription) string {
+	if f.Folder != "" {
+		return f.Folder
+	}
+	return m.Folder
+}
+
+// BuildOn marks the files that are unchanged from a previous manifest as
+// referring to the objects that were already uploaded and returns the
+// files that still need to be uploaded.
+func (m *Manifest) BuildOn(previous Manifest) []FileDescription {
+	d := DiffFiles(previous.Files, m.Files)
+	prior := make(map[string]FileDescription)
+	for _, f := range previous.Files {
+		prior[CleanName(f.Name)] = f
+	}
+	unchanged := make(map[string]FileDescription)
+	for _, f := range d.Unchanged {
+		unchanged[CleanName(f.Name)] = prior[CleanName(f.Name)]
+	}
+
+	var upload []FileDescription
+	for i := range m.Files {
+		if p, ok := unchanged[CleanName(m.Files[i].Name)]; ok {
+			m.Files[i].Folder = previous.ObjectFolder(p)
+			m.Files[i].Key = p.Key
+			m.Files[i].ETag = p.ETag
+			m.Files[i].Checksum = p.Checksum
+			m.Files[i].PartSize = p.PartSize
+		} else {
+			upload = append(upload, m.Files[i])
+		}
+	}
+	m.Since = previous.Folder
+	return upload
+}
+
+// ParseManifest from the contents of a manifest file.
+func ParseManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	err := json.Unmarshal(data, &m)
+	return m, err
+}
+
+// ObjectKey is the key of the object holding a file in the bucket.
+// Manifests from before keys were recorded always used gpg.
+func (m Manifest) ObjectKey(f FileDescription) string {
+	if f.Key != "" {
+		return f.Key
+	}
+	return filepath.Clean(m.ObjectFolder(f) + "/" + f.Name + ".zip.gpg")
 }
 
 // ReadManifest from a file.
@@ -62,26 +220,17 @@ func ReadManifest(file string) Manifest {
 	if err != nil {
 		log.Error(err)
 	}
-	json.Unmarshal(bytes, &m)
+	if err := json.Unmarshal(bytes, &m); err != nil {
+		log.Error(err)
+	}
 	return m
 }
 
 // BuildManifest builds a manifest from a directory.
 // It reads the contents of the directory and captures the file names,
-// owners, dates and user into a manifest.json file.
+// owners, dates and user into a Manifest.
 func BuildManifest(folder string, options options.Options) Manifest {
-	var files []FileDescription
-	err := filepath.Walk(options.Directory,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() && !strings.HasSuffix(path, "manifest.json") {
-				sha256hash := hash(path, options)
-				files = append(files, FileDescription{strings.Replace(path, options.Directory, "", -1), info.Size(), info.ModTime(), sha256hash})
-			}
-			return nil
-		})
+	files, _, err := ScanDirectory(options)
 	if err != nil {
 		log.Error(err)
 	}
@@ -89,7 +238,7 @@ func BuildManifest(folder string, options options.Options) Manifest {
 	user, err := user.Current()
 	sudoUser := os.Getenv("SUDO_USER") // In case they are sudo'ing, we can know the acting user.
 	manifest := Manifest{
-		Name:         filepath.Clean("/s3s2_manifest.json"),
+		Name:         filepath.Clean("/" + FileName),
 		Timestamp:    time.Now(),
 		Organization: options.Org,
 		Username:     user.Name,
@@ -97,12 +246,59 @@ func BuildManifest(folder string, options options.Options) Manifest {
 		SudoUser:     sudoUser,
 		Folder:       folder,
 		Files:        files,
+		Bucket:       options.Bucket,
+		AwsKey:       options.AwsKey,
+	}
+	if manifest.Hashed() {
+		manifest.Root = MerkleRoot(files)
 	}
-
-	writeManifest(manifest, options.Directory)
 	return manifest
 }
 
+// ScanDirectory walks the directory to share and describes the files
+// that should be shared.  It also returns the paths that were left out
+// by the include and exclude patterns or the .s3s2ignore file.
+func ScanDirectory(options options.Options) ([]FileDescription, []string, error) {
+	var files []FileDescription
+	var excluded []string
+
+	matcher := ignore.NewMatcher(options.Include, options.Exclude)
+	if err := matcher.ReadFile(filepath.Join(options.Directory, ignore.FileName)); err != nil {
+		return nil, nil, err
+	}
+
+	err := filepath.Walk(options.Directory,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(options.Directory, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !matcher.Included(rel, info.IsDir()) {
+				excluded = append(excluded, rel)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() && !strings.HasSuffix(path, "manifest.json") && !metadataFile(info.Name()) {
+				sha256hash := hash(path, options)
+				files = append(files, FileDescription{Name: strings.Replace(path, options.Directory, "", -1), Size: info.Size(), Modified: info.ModTime(), Hash: sha256hash})
+			}
+			return nil
+		})
+	return files, excluded, err
+}
+
+// metadataFile reports whether a file in the share root is one of ours
+// rather than something to share.
+func metadataFile(name string) bool {
+	return name == ignore.FileName || name == policy.LabelFileName
+}
+
 // CleanupFile just deletes a file.
 func CleanupFile(fn string) {
 	var err = os.Remove(fn)
@@ -113,6 +309,11 @@ func CleanupFile(fn string) {
 	}
 }
 
+// HashFile is the SHA-256 of a file, as a manifest records it.
+func HashFile(file string) string {
+	return hash(file, options.Options{Hash: true})
+}
+
 func hash(file string, options options.Options) string {
 	start := time.Now()
 	var hash string
@@ -126,7 +327,7 @@ func hash(file string, options options.Options) string {
 		hash = hex.EncodeToString(hasher.Sum(nil))
 	} else {
 		// Don't actually hash the file.
-		hash = "fake-hash"
+		hash = FakeHash
 	}
 
 	current := time.Now()
@@ -135,10 +336,44 @@ func hash(file string, options options.Options) string {
 	return hash
 }
 
-func writeManifest(manifest Manifest, directory string) error {
+// Digest is the SHA-256 of the manifest as it is written to the bucket.
+// Presigned links are left out, so adding them does not change it.
+func Digest(manifest Manifest) string {
+	manifest.Links, manifest.LinksExpire = nil, nil
+	file, _ := json.MarshalIndent(manifest, "", " ")
+	sum := sha256.Sum256(file)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignedDigest is the SHA-256 of everything in the manifest but the
+// signature itself.  It is what the sender signs.
+func SignedDigest(manifest Manifest) string {
+	manifest.Signature, manifest.Signer = "", ""
+	file, _ := json.MarshalIndent(manifest, "", " ")
+	sum := sha256.Sum256(file)
+	return hex.EncodeToString(sum[:])
+}
+
+// ShareDigest is the SHA-256 of the manifest without what changes as
+// the share is uploaded or signed: the objects, checksums and pending
+// files and the signature.  It is the same for every object of the
+// share, so each one can carry it.
+func ShareDigest(manifest Manifest) string {
+	files := make([]FileDescription, len(manifest.Files))
+	for i, f := range manifest.Files {
+		f.Key, f.ETag, f.Checksum, f.PartSize = "", "", "", 0
+		files[i] = f
+	}
+	manifest.Files = files
+	manifest.Pending = nil
+	manifest.Signature, manifest.Signer = "", ""
+	return Digest(manifest)
+}
+
+// WriteManifest writes the manifest.json file into a directory.
+func WriteManifest(manifest Manifest, directory string) error {
 	file, _ := json.MarshalIndent(manifest, "", " ")
 	filename := directory + "/s3s2_manifest.json"
 	log.Debug(filename)
-	ioutil.WriteFile(filename, file, 0644)
-	return nil
+	return ioutil.WriteFile(filename, file, 0644)
 }
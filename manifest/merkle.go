@@ -0,0 +1,152 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// The Merkle tree is built over the files sorted by name.  Each leaf
+// commits to the file name and its hash.  Leaves and inner nodes use
+// different prefixes so a leaf can never be passed off as a node.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ProofStep is one sibling on the path from a leaf to the root.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// Proof shows that a file is included in a share with a given root
+// without needing any of the other files.
+type Proof struct {
+	Root  string      `json:"root"`
+	Name  string      `json:"name"`
+	Hash  string      `json:"hash"`
+	Steps []ProofStep `json:"steps"`
+}
+
+// MerkleRoot computes the root over the hashes of the files.
+// It returns an empty string if there are no files.
+func MerkleRoot(files []FileDescription) string {
+	level := leaves(files)
+	if len(level) == 0 {
+		return ""
+	}
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// BuildProof builds the inclusion proof for the named file.
+func BuildProof(files []FileDescription, name string) (Proof, error) {
+	sorted := sortedFiles(files)
+	index := -1
+	for i, f := range sorted {
+		if CleanName(f.Name) == CleanName(name) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return Proof{}, fmt.Errorf("%s is not in the manifest", name)
+	}
+
+	proof := Proof{Name: CleanName(name), Hash: sorted[index].Hash}
+	level := leaves(sorted)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		level = nextLevel(level)
+		index = index / 2
+	}
+	proof.Root = hex.EncodeToString(level[0])
+	return proof, nil
+}
+
+// VerifyProof checks that the proof leads from the file to the root.
+func VerifyProof(root string, proof Proof) bool {
+	current := leaf(proof.Name, proof.Hash)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			current = node(sibling, current)
+		} else {
+			current = node(current, sibling)
+		}
+	}
+	return hex.EncodeToString(current) == root
+}
+
+func sortedFiles(files []FileDescription) []FileDescription {
+	sorted := make([]FileDescription, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return CleanName(sorted[i].Name) < CleanName(sorted[j].Name) })
+	return sorted
+}
+
+func leaves(files []FileDescription) [][]byte {
+	var level [][]byte
+	for _, f := range sortedFiles(files) {
+		level = append(level, leaf(CleanName(f.Name), f.Hash))
+	}
+	return level
+}
+
+// nextLevel pairs up the nodes of a level.  An odd node out is
+// carried up to the next level unchanged.
+func nextLevel(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, node(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+func leaf(name string, hash string) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(hash))
+	return h.Sum(nil)
+}
+
+func node(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
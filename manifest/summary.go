@@ -0,0 +1,167 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary describes a share in a bucket, from its manifest.
+type Summary struct {
+	ShareID      string     `json:"share_id"`
+	Manifest     string     `json:"manifest"`
+	Organization string     `json:"organization"`
+	Sender       string     `json:"sender"`
+	Shared       time.Time  `json:"shared"`
+	FileCount    int        `json:"file_count"`
+	TotalSize    int64      `json:"total_size"`
+	Complete     bool       `json:"complete"`
+	Pending      int        `json:"pending,omitempty"`
+	Labels       []string   `json:"labels,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	Digest       string     `json:"manifest_digest"`
+	// Uses are the earlier shares whose objects this one refers to.
+	Uses []string `json:"uses,omitempty"`
+	// Tags are the tags on the manifest object, when they were read.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Summarize a share from its manifest and the key it was read from.
+func Summarize(key string, m Manifest) Summary {
+	s := Summary{
+		ShareID:      m.Folder,
+		Manifest:     key,
+		Organization: m.Organization,
+		Sender:       m.User,
+		Shared:       m.Timestamp,
+		FileCount:    len(m.Files),
+		Complete:     m.Complete(),
+		Pending:      len(m.Pending),
+		Labels:       m.AllLabels(),
+		Expires:      m.Expires,
+		Digest:       Digest(m),
+	}
+	if s.ShareID == "" {
+		s.ShareID = path.Dir(key)
+	}
+	if m.SudoUser != "" {
+		s.Sender = m.SudoUser + " (as " + m.User + ")"
+	}
+	for _, f := range m.Files {
+		s.TotalSize += f.Size
+		if f.Folder != "" && f.Folder != m.Folder && !contains(s.Uses, f.Folder) {
+			s.Uses = append(s.Uses, f.Folder)
+		}
+	}
+	return s
+}
+
+// Status is "complete" or how many files the share is still missing.
+func (s Summary) Status() string {
+	if s.Complete {
+		return "complete"
+	}
+	return fmt.Sprintf("incomplete (%d pending)", s.Pending)
+}
+
+// Filter picks the shares from an organization, when given, that were
+// shared in a time range.  A zero time leaves that end open.  With
+// ExpiredBy, only shares due to expire by then pass.
+type Filter struct {
+	Organization string
+	After        time.Time
+	Before       time.Time
+	ExpiredBy    time.Time
+}
+
+// Matches reports whether a share passes the filter.
+func (f Filter) Matches(s Summary) bool {
+	if f.Organization != "" && !strings.EqualFold(f.Organization, s.Organization) {
+		return false
+	}
+	if !f.After.IsZero() && s.Shared.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !s.Shared.Before(f.Before) {
+		return false
+	}
+	if !f.ExpiredBy.IsZero() && (s.Expires == nil || s.Expires.After(f.ExpiredBy)) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SortSummaries orders shares by time, org, sender, files, size or id.
+func SortSummaries(summaries []Summary, by string, reverse bool) error {
+	var less func(a, b Summary) bool
+	switch by {
+	case "time", "":
+		less = func(a, b Summary) bool { return a.Shared.Before(b.Shared) }
+	case "org":
+		less = func(a, b Summary) bool { return a.Organization < b.Organization }
+	case "sender":
+		less = func(a, b Summary) bool { return a.Sender < b.Sender }
+	case "files":
+		less = func(a, b Summary) bool { return a.FileCount < b.FileCount }
+	case "size":
+		less = func(a, b Summary) bool { return a.TotalSize < b.TotalSize }
+	case "id":
+		less = func(a, b Summary) bool { return a.ShareID < b.ShareID }
+	default:
+		return fmt.Errorf("unknown sort %q, use time, org, sender, files, size or id", by)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if reverse {
+			return less(summaries[j], summaries[i])
+		}
+		return less(summaries[i], summaries[j])
+	})
+	return nil
+}
+
+// StillUsed finds the shares about to be purged whose objects a share
+// that is kept refers to, and so must be kept as well.  It returns them
+// with a share that uses them.
+func StillUsed(all []Summary, purge map[string]bool) map[string]string {
+	used := map[string]string{}
+	for changed := true; changed; {
+		changed = false
+		for _, s := range all {
+			if purge[s.ShareID] && used[s.ShareID] == "" {
+				continue
+			}
+			for _, u := range s.Uses {
+				if purge[u] && used[u] == "" {
+					used[u] = s.ShareID
+					changed = true
+				}
+			}
+		}
+	}
+	return used
+}
@@ -0,0 +1,188 @@
+package main_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	"github.com/jemurai/s3s2/storage"
+)
+
+var _ = Describe("S3 compatible stores", func() {
+	Describe("Config", func() {
+		It("should use the endpoint with path style addressing", func() {
+			config, err := s3helper.Config(options.Options{
+				Endpoint:  "http://localhost:9000",
+				PathStyle: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(aws.StringValue(config.Endpoint)).To(Equal("http://localhost:9000"))
+			Expect(aws.StringValue(config.Region)).To(Equal("us-east-1"))
+			Expect(aws.BoolValue(config.S3ForcePathStyle)).To(BeTrue())
+			Expect(aws.BoolValue(config.DisableSSL)).To(BeTrue())
+		})
+
+		It("should reject an endpoint without a host", func() {
+			_, err := s3helper.Config(options.Options{Endpoint: "minio"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject a CA bundle without certificates", func() {
+			dir, _ := ioutil.TempDir("", "s3s2-ca")
+			defer os.RemoveAll(dir)
+			bundle := filepath.Join(dir, "ca.pem")
+			ioutil.WriteFile(bundle, []byte("not a certificate"), 0600)
+			_, err := s3helper.Config(options.Options{Endpoint: "https://ceph.local", CABundle: bundle})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should pick the server side encryption mode", func() {
+			Expect(s3helper.ServerSideEncryption(options.Options{AwsKey: "key"})).To(Equal("aws:kms"))
+			Expect(s3helper.ServerSideEncryption(options.Options{AwsKey: "key", SSE: "none"})).To(Equal(""))
+			Expect(s3helper.ServerSideEncryption(options.Options{SSE: "aes256"})).To(Equal("AES256"))
+			Expect(s3helper.ServerSideEncryption(options.Options{})).To(Equal(""))
+			Expect(s3helper.ServerSideEncryption(options.Options{SSE: "sse-c"})).To(Equal(s3helper.SSECustomer))
+			Expect(s3helper.ServerSideEncryption(options.Options{SSECustomerKeyFile: "key"})).To(Equal(s3helper.SSECustomer))
+		})
+
+		It("should reject an unknown server side encryption mode", func() {
+			Expect(s3helper.CheckServerSideEncryption(options.Options{SSE: "AES256"})).To(Succeed())
+			Expect(s3helper.CheckServerSideEncryption(options.Options{})).To(Succeed())
+			Expect(s3helper.CheckServerSideEncryption(options.Options{SSE: "aes-256"})).NotTo(Succeed())
+		})
+
+		It("should read an SSE-C key from a file or the environment", func() {
+			dir, _ := ioutil.TempDir("", "s3s2-ssec")
+			defer os.RemoveAll(dir)
+			raw := []byte("0123456789abcdef0123456789abcdef")
+			encoded := base64.StdEncoding.EncodeToString(raw)
+
+			keyFile := filepath.Join(dir, "raw.key")
+			ioutil.WriteFile(keyFile, raw, 0600)
+			key, err := s3helper.CustomerKey(options.Options{SSECustomerKeyFile: keyFile})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(raw))
+
+			ioutil.WriteFile(keyFile, []byte(encoded+"\n"), 0600)
+			key, err = s3helper.CustomerKey(options.Options{SSECustomerKeyFile: keyFile})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(raw))
+
+			ioutil.WriteFile(keyFile, []byte("too short"), 0600)
+			_, err = s3helper.CustomerKey(options.Options{SSECustomerKeyFile: keyFile})
+			Expect(err).To(HaveOccurred())
+
+			os.Setenv(s3helper.CustomerKeyEnv, encoded)
+			defer os.Unsetenv(s3helper.CustomerKeyEnv)
+			key, err = s3helper.CustomerKey(options.Options{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(raw))
+			Expect(s3helper.CustomerKeyMD5(key)).To(HaveLen(24))
+			Expect(s3helper.CustomerKeyMD5(key)).NotTo(ContainSubstring(encoded))
+		})
+	})
+
+	// Runs against a real store when S3S2_TEST_ENDPOINT and
+	// S3S2_TEST_BUCKET are set, for example a local MinIO:
+	//
+	//   docker run -p 9000:9000 minio/minio server /data
+	//
+	// with AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY set to its keys.
+	Describe("Conformance", func() {
+		var (
+			store  storage.Storage
+			prefix string
+		)
+
+		BeforeEach(func() {
+			endpoint := os.Getenv("S3S2_TEST_ENDPOINT")
+			bucket := os.Getenv("S3S2_TEST_BUCKET")
+			if endpoint == "" || bucket == "" {
+				Skip("S3S2_TEST_ENDPOINT and S3S2_TEST_BUCKET are not set")
+			}
+			s, err := s3helper.NewStore(options.Options{
+				Bucket:    bucket,
+				Region:    os.Getenv("S3S2_TEST_REGION"),
+				Endpoint:  endpoint,
+				PathStyle: true,
+				CABundle:  os.Getenv("S3S2_TEST_CA_BUNDLE"),
+				SSE:       os.Getenv("S3S2_TEST_SSE"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			store = s
+			id, _ := uuid.NewV4()
+			prefix = "s3s2-conformance-" + id.String() + "/"
+		})
+
+		AfterEach(func() {
+			if store == nil {
+				return
+			}
+			objects, _ := store.List(prefix)
+			for _, o := range objects {
+				store.Delete(o.Key)
+			}
+		})
+
+		It("should put, stat, get, list and delete objects", func() {
+			key := prefix + "a.txt"
+			info, err := store.Put(key, strings.NewReader("hello"), storage.PutOptions{Tags: map[string]string{"org": "test"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ETag).NotTo(BeEmpty())
+
+			stat, err := store.Stat(key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Size).To(Equal(int64(5)))
+
+			data, err := storage.ReadObject(store, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("hello"))
+
+			objects, err := store.List(prefix)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(objects).To(HaveLen(1))
+
+			Expect(store.Delete(key)).To(Succeed())
+			_, err = store.Stat(key)
+			Expect(err).To(Equal(storage.ErrNotFound))
+		})
+
+		It("should keep tags and metadata with objects", func() {
+			key := prefix + "c.txt"
+			_, err := storage.WriteObject(store, key, []byte("tagged"), storage.PutOptions{
+				Tags:     map[string]string{"org": "Test", "share": "s3s2_conformance"},
+				Metadata: map[string]string{"s3s2-version": "dev"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			details, err := storage.Describe(store, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(details.Tags).To(HaveKeyWithValue("share", "s3s2_conformance"))
+			Expect(details.Metadata).To(HaveKeyWithValue("s3s2-version", "dev"))
+			Expect(details.StorageClass).NotTo(BeEmpty())
+		})
+
+		It("should download to a file", func() {
+			key := prefix + "b.txt"
+			_, err := storage.WriteObject(store, key, []byte("world"), storage.PutOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			dir, _ := ioutil.TempDir("", "s3s2-conformance")
+			defer os.RemoveAll(dir)
+			fn := filepath.Join(dir, "b.txt")
+			Expect(storage.GetFile(store, key, fn)).To(Succeed())
+			data, _ := ioutil.ReadFile(fn)
+			Expect(string(data)).To(Equal("world"))
+		})
+	})
+})
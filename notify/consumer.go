@@ -0,0 +1,142 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Message is a message received from a queue.
+type Message struct {
+	ID      string
+	Body    string
+	Receipt string
+	// Receives counts the times the message has been delivered,
+	// including this one.
+	Receives int
+}
+
+// Queue is where event notifications arrive.
+type Queue interface {
+	// Receive waits up to wait for at most max messages.  Messages are
+	// hidden from other receivers until they are deleted or a timeout
+	// passes, when they are delivered again.
+	Receive(max int, wait time.Duration) ([]Message, error)
+	// Delete removes a message that has been handled.
+	Delete(m Message) error
+}
+
+// DeadLetter keeps a message that can never be handled somewhere a
+// person can look at it, with the reason.
+type DeadLetter func(m Message, reason error) error
+
+// PoisonError is returned by a Handle that knows the message will never
+// be handled, so it is dead lettered without waiting for MaxReceives.
+type PoisonError struct {
+	Err error
+}
+
+func (e PoisonError) Error() string {
+	return e.Err.Error()
+}
+
+// Poison marks an error as one that retrying the message will not fix.
+func Poison(err error) error {
+	return PoisonError{Err: err}
+}
+
+// Consumer handles the objects in the event notifications on a queue.
+// A message is only deleted once every object in it has been handled.
+// One that cannot be read, or has failed MaxReceives times, is poison
+// and is given to DeadLetter instead.
+type Consumer struct {
+	Queue       Queue
+	Handle      func(o Object) error
+	DeadLetter  DeadLetter
+	MaxReceives int
+	Batch       int
+	Wait        time.Duration
+}
+
+// Poll receives one batch of messages and handles them.  It returns
+// how many messages were received and how many of those could not be
+// handled this time.
+func (c *Consumer) Poll() (int, int, error) {
+	batch := c.Batch
+	if batch <= 0 {
+		batch = 1
+	}
+	messages, err := c.Queue.Receive(batch, c.Wait)
+	if err != nil {
+		return 0, 0, err
+	}
+	failed := 0
+	for _, m := range messages {
+		if !c.handle(m) {
+			failed++
+		}
+	}
+	return len(messages), failed, nil
+}
+
+// handle handles one message and reports whether it is done with.
+func (c *Consumer) handle(m Message) bool {
+	objects, err := ParseEvent(m.Body)
+	if err != nil {
+		return c.poison(m, fmt.Errorf("unable to read the message, %v", err))
+	}
+	for _, o := range objects {
+		if !o.Created() {
+			continue
+		}
+		if err = c.Handle(o); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		if err := c.Queue.Delete(m); err != nil {
+			log.Errorf("Unable to delete message %s, %v", m.ID, err)
+		}
+		return true
+	}
+	if _, ok := err.(PoisonError); ok {
+		return c.poison(m, err)
+	}
+	if c.MaxReceives > 0 && m.Receives >= c.MaxReceives {
+		return c.poison(m, fmt.Errorf("failed %d times, last with %v", m.Receives, err))
+	}
+	log.Warnf("Message %s failed on delivery %d and will be retried: %v", m.ID, m.Receives, err)
+	return false
+}
+
+// poison sends a message to the dead letter handler and deletes it.  If
+// there is no handler, or it fails, the message is left on the queue.
+func (c *Consumer) poison(m Message, reason error) bool {
+	log.Errorf("Message %s is poison: %v", m.ID, reason)
+	if c.DeadLetter == nil {
+		return false
+	}
+	if err := c.DeadLetter(m, reason); err != nil {
+		log.Errorf("Unable to dead letter message %s, %v", m.ID, err)
+		return false
+	}
+	if err := c.Queue.Delete(m); err != nil {
+		log.Errorf("Unable to delete message %s, %v", m.ID, err)
+	}
+	return false
+}
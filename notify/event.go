@@ -0,0 +1,91 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify reacts to S3 event notifications delivered to a queue.
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Object is an object an S3 event notification is about.
+type Object struct {
+	Bucket string
+	Key    string
+	Event  string
+	Size   int64
+}
+
+// Created reports whether the event is for a new object.
+func (o Object) Created() bool {
+	return strings.HasPrefix(o.Event, "ObjectCreated:")
+}
+
+type event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				Size int64  `json:"size"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+
+	// S3 sends a test event when notifications are set up.
+	Event string `json:"Event"`
+
+	// Notifications sent through SNS wrap the S3 event.
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// ParseEvent reads the objects from an S3 event notification, sent
+// straight to the queue or through SNS.  A test event has no objects.
+func ParseEvent(body string) ([]Object, error) {
+	var e event
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return nil, err
+	}
+	if e.Type == "Notification" && e.Message != "" {
+		return ParseEvent(e.Message)
+	}
+	if e.Event == "s3:TestEvent" {
+		return nil, nil
+	}
+	if len(e.Records) == 0 {
+		return nil, errors.New("not an S3 event notification")
+	}
+	var objects []Object
+	for _, r := range e.Records {
+		// Keys are URL encoded, with + for spaces.
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, Object{
+			Bucket: r.S3.Bucket.Name,
+			Key:    key,
+			Event:  r.EventName,
+			Size:   r.S3.Object.Size,
+		})
+	}
+	return objects, nil
+}
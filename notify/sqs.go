@@ -0,0 +1,177 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// SQS is a queue in Amazon SQS or a store that speaks its API, such as
+// ElasticMQ.
+type SQS struct {
+	svc        *sqs.SQS
+	url        string
+	visibility time.Duration
+}
+
+// NewSQS opens the queue at the URL.  Messages received are hidden for
+// the visibility timeout, or the queue's own when it is zero.
+func NewSQS(sess *session.Session, queueURL string, region string, visibility time.Duration) (*SQS, error) {
+	config, err := QueueConfig(queueURL, region)
+	if err != nil {
+		return nil, err
+	}
+	return &SQS{svc: sqs.New(sess, config), url: queueURL, visibility: visibility}, nil
+}
+
+// QueueConfig points a client at the service hosting the queue URL.
+// The session may be for an S3 compatible store elsewhere, so the
+// endpoint always comes from the URL unless it is in AWS.
+func QueueConfig(queueURL string, region string) (*aws.Config, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid queue URL %q", queueURL)
+	}
+	config := &aws.Config{
+		Endpoint:   aws.String(""),
+		DisableSSL: aws.Bool(u.Scheme == "http"),
+	}
+	host := u.Hostname()
+	if strings.HasSuffix(host, ".amazonaws.com") {
+		// sqs.<region>.amazonaws.com or <region>.queue.amazonaws.com
+		parts := strings.Split(host, ".")
+		if parts[0] == "sqs" {
+			region = parts[1]
+		} else if len(parts) > 1 && parts[1] == "queue" {
+			region = parts[0]
+		}
+	} else {
+		config.Endpoint = aws.String(u.Scheme + "://" + u.Host)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	config.Region = aws.String(region)
+	return config, nil
+}
+
+// Receive long polls for messages, for up to 20 seconds.
+func (q *SQS) Receive(max int, wait time.Duration) ([]Message, error) {
+	if max > 10 {
+		max = 10
+	}
+	if wait > 20*time.Second {
+		wait = 20 * time.Second
+	}
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds:     aws.Int64(int64(wait / time.Second)),
+		AttributeNames:      aws.StringSlice([]string{"ApproximateReceiveCount"}),
+	}
+	if q.visibility > 0 {
+		input.VisibilityTimeout = aws.Int64(int64(q.visibility / time.Second))
+	}
+	output, err := q.svc.ReceiveMessage(input)
+	if err != nil {
+		return nil, err
+	}
+	var messages []Message
+	for _, m := range output.Messages {
+		receives, _ := strconv.Atoi(aws.StringValue(m.Attributes["ApproximateReceiveCount"]))
+		messages = append(messages, Message{
+			ID:       aws.StringValue(m.MessageId),
+			Body:     aws.StringValue(m.Body),
+			Receipt:  aws.StringValue(m.ReceiptHandle),
+			Receives: receives,
+		})
+	}
+	return messages, nil
+}
+
+// Delete removes a message that has been handled.
+func (q *SQS) Delete(m Message) error {
+	_, err := q.svc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: aws.String(m.Receipt),
+	})
+	return err
+}
+
+// Send puts a message on the queue.
+func (q *SQS) Send(body string, attributes map[string]string) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(body),
+	}
+	if len(attributes) > 0 {
+		input.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		for k, v := range attributes {
+			input.MessageAttributes[k] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
+			}
+		}
+	}
+	_, err := q.svc.SendMessage(input)
+	return err
+}
+
+// QueueDeadLetter forwards poison messages to another queue, with the
+// reason and the original message id as attributes.
+func QueueDeadLetter(q *SQS) DeadLetter {
+	return func(m Message, reason error) error {
+		return q.Send(m.Body, map[string]string{
+			"s3s2-reason":     reason.Error(),
+			"s3s2-message-id": m.ID,
+		})
+	}
+}
+
+// FileDeadLetter writes poison messages to files in a directory.
+func FileDeadLetter(dir string) DeadLetter {
+	return func(m Message, reason error) error {
+		data, err := json.MarshalIndent(struct {
+			ID       string    `json:"id"`
+			Body     string    `json:"body"`
+			Reason   string    `json:"reason"`
+			Receives int       `json:"receives"`
+			Time     time.Time `json:"time"`
+		}{m.ID, m.Body, reason.Error(), m.Receives, time.Now().UTC()}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(m.ID)
+		if name == "" {
+			name = strconv.FormatInt(time.Now().UnixNano(), 10)
+		}
+		return ioutil.WriteFile(filepath.Join(dir, name+".json"), data, 0600)
+	}
+}
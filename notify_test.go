@@ -0,0 +1,260 @@
+package main_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/jemurai/s3s2/notify"
+	"github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+)
+
+const s3Event = `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"inbox"},"object":{"key":"acme_s3s2_1/my+file%281%29.csv.zip.gpg","size":42}}},
+{"eventName":"ObjectRemoved:Delete","s3":{"bucket":{"name":"inbox"},"object":{"key":"acme_s3s2_1/s3s2_manifest.json"}}}]}`
+
+// memoryQueue is a queue that delivers each message again until it is
+// deleted, like SQS after its visibility timeout.
+type memoryQueue struct {
+	messages []notify.Message
+	deleted  []string
+}
+
+func (q *memoryQueue) Receive(max int, wait time.Duration) ([]notify.Message, error) {
+	var received []notify.Message
+	for i := range q.messages {
+		if len(received) == max {
+			break
+		}
+		q.messages[i].Receives++
+		received = append(received, q.messages[i])
+	}
+	return received, nil
+}
+
+func (q *memoryQueue) Delete(m notify.Message) error {
+	for i := range q.messages {
+		if q.messages[i].ID == m.ID {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			q.deleted = append(q.deleted, m.ID)
+			return nil
+		}
+	}
+	return errors.New("no such message")
+}
+
+var _ = Describe("Event notifications", func() {
+	It("should read the objects from an S3 event", func() {
+		objects, err := notify.ParseEvent(s3Event)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(2))
+		Expect(objects[0]).To(Equal(notify.Object{Bucket: "inbox", Key: "acme_s3s2_1/my file(1).csv.zip.gpg", Event: "ObjectCreated:Put", Size: 42}))
+		Expect(objects[0].Created()).To(BeTrue())
+		Expect(objects[1].Created()).To(BeFalse())
+	})
+
+	It("should read S3 events sent through SNS", func() {
+		wrapped, _ := json.Marshal(s3Event)
+		objects, err := notify.ParseEvent(`{"Type":"Notification","Message":` + string(wrapped) + `}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(2))
+	})
+
+	It("should accept test events and reject other messages", func() {
+		objects, err := notify.ParseEvent(`{"Service":"Amazon S3","Event":"s3:TestEvent"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(BeEmpty())
+		_, err = notify.ParseEvent(`{"hello":"world"}`)
+		Expect(err).To(HaveOccurred())
+		_, err = notify.ParseEvent(`not json`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should find the service for a queue URL", func() {
+		config, err := notify.QueueConfig("https://sqs.eu-west-1.amazonaws.com/123456789012/inbox", "us-east-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(aws.StringValue(config.Region)).To(Equal("eu-west-1"))
+		Expect(aws.StringValue(config.Endpoint)).To(Equal(""))
+
+		config, err = notify.QueueConfig("http://localhost:9324/000000000000/inbox", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(aws.StringValue(config.Endpoint)).To(Equal("http://localhost:9324"))
+		Expect(aws.StringValue(config.Region)).To(Equal("us-east-1"))
+		Expect(aws.BoolValue(config.DisableSSL)).To(BeTrue())
+
+		_, err = notify.QueueConfig("inbox", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Consumer", func() {
+		var (
+			queue   *memoryQueue
+			handled []string
+			dead    []string
+			fail    bool
+			c       *notify.Consumer
+		)
+
+		BeforeEach(func() {
+			queue = &memoryQueue{}
+			handled, dead, fail = nil, nil, false
+			c = &notify.Consumer{
+				Queue:       queue,
+				MaxReceives: 3,
+				Batch:       10,
+				Handle: func(o notify.Object) error {
+					if fail {
+						return errors.New("boom")
+					}
+					handled = append(handled, o.Key)
+					return nil
+				},
+				DeadLetter: func(m notify.Message, reason error) error {
+					dead = append(dead, m.ID)
+					return nil
+				},
+			}
+		})
+
+		It("should delete a message once it is handled", func() {
+			queue.messages = []notify.Message{{ID: "1", Body: s3Event}}
+			received, failed, err := c.Poll()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received).To(Equal(1))
+			Expect(failed).To(Equal(0))
+			Expect(handled).To(Equal([]string{"acme_s3s2_1/my file(1).csv.zip.gpg"}))
+			Expect(queue.deleted).To(Equal([]string{"1"}))
+		})
+
+		It("should keep a failed message until it is poison", func() {
+			queue.messages = []notify.Message{{ID: "1", Body: s3Event}}
+			fail = true
+			for i := 0; i < 2; i++ {
+				_, failed, _ := c.Poll()
+				Expect(failed).To(Equal(1))
+			}
+			Expect(queue.messages).To(HaveLen(1))
+			Expect(dead).To(BeEmpty())
+
+			c.Poll()
+			Expect(queue.messages).To(BeEmpty())
+			Expect(dead).To(Equal([]string{"1"}))
+		})
+
+		It("should dead letter a message that will never be handled at once", func() {
+			queue.messages = []notify.Message{{ID: "1", Body: s3Event}}
+			c.Handle = func(o notify.Object) error {
+				return notify.Poison(errors.New("out of attempts"))
+			}
+			_, failed, _ := c.Poll()
+			Expect(failed).To(Equal(1))
+			Expect(dead).To(Equal([]string{"1"}))
+			Expect(queue.messages).To(BeEmpty())
+		})
+
+		It("should dead letter a message it cannot read at once", func() {
+			queue.messages = []notify.Message{{ID: "1", Body: "garbage"}, {ID: "2", Body: s3Event}}
+			_, failed, _ := c.Poll()
+			Expect(failed).To(Equal(1))
+			Expect(dead).To(Equal([]string{"1"}))
+			Expect(queue.deleted).To(ConsistOf("1", "2"))
+		})
+
+		It("should write dead letters to files", func() {
+			dir, _ := ioutil.TempDir("", "s3s2-dead")
+			defer os.RemoveAll(dir)
+			Expect(notify.FileDeadLetter(dir)(notify.Message{ID: "a/b", Body: "garbage"}, errors.New("unreadable"))).To(Succeed())
+			data, err := ioutil.ReadFile(filepath.Join(dir, "a_b.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("unreadable"))
+		})
+	})
+
+	// Runs against an SQS compatible queue when S3S2_TEST_QUEUE_ENDPOINT
+	// is set, for example a local ElasticMQ:
+	//
+	//   docker run -p 9324:9324 softwaremill/elasticmq
+	//
+	// with AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY set to anything.
+	Describe("Conformance", func() {
+		var (
+			svc      *sqs.SQS
+			queueURL string
+			dlqURL   string
+		)
+
+		create := func(name string) string {
+			out, err := svc.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)})
+			Expect(err).NotTo(HaveOccurred())
+			return aws.StringValue(out.QueueUrl)
+		}
+
+		BeforeEach(func() {
+			endpoint := os.Getenv("S3S2_TEST_QUEUE_ENDPOINT")
+			if endpoint == "" {
+				Skip("S3S2_TEST_QUEUE_ENDPOINT is not set")
+			}
+			sess, err := s3helper.Session(options.Options{Endpoint: endpoint})
+			Expect(err).NotTo(HaveOccurred())
+			config, err := notify.QueueConfig(endpoint+"/queue", "")
+			Expect(err).NotTo(HaveOccurred())
+			svc = sqs.New(sess, config)
+			id, _ := uuid.NewV4()
+			queueURL = create("s3s2-test-" + id.String())
+			dlqURL = create("s3s2-test-dlq-" + id.String())
+		})
+
+		AfterEach(func() {
+			if svc != nil {
+				svc.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(queueURL)})
+				svc.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(dlqURL)})
+			}
+		})
+
+		It("should consume, delete and dead letter messages", func() {
+			sess, _ := s3helper.Session(options.Options{Endpoint: os.Getenv("S3S2_TEST_QUEUE_ENDPOINT")})
+			queue, err := notify.NewSQS(sess, queueURL, "", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			dlq, err := notify.NewSQS(sess, dlqURL, "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queue.Send(s3Event, nil)).To(Succeed())
+			Expect(queue.Send("garbage", nil)).To(Succeed())
+
+			var handled []string
+			c := &notify.Consumer{
+				Queue:      queue,
+				DeadLetter: notify.QueueDeadLetter(dlq),
+				Batch:      10,
+				Wait:       time.Second,
+				Handle: func(o notify.Object) error {
+					handled = append(handled, o.Key)
+					return nil
+				},
+			}
+			received := 0
+			for i := 0; i < 5 && received < 2; i++ {
+				n, _, err := c.Poll()
+				Expect(err).NotTo(HaveOccurred())
+				received += n
+			}
+			Expect(received).To(Equal(2))
+			Expect(handled).To(HaveLen(1))
+
+			left, _ := queue.Receive(10, time.Second)
+			Expect(left).To(BeEmpty())
+			dead, _ := dlq.Receive(10, time.Second)
+			Expect(dead).To(HaveLen(1))
+			Expect(dead[0].Body).To(Equal("garbage"))
+		})
+	})
+})
@@ -1,21 +1,98 @@
 package options
 
+import (
+	"time"
+
+	"github.com/jemurai/s3s2/policy"
+)
+
 // Options is the information we need about a particular sharing activity.
 type Options struct {
 	// For both encrypt/decrypt
 	Region string `json:"region"`
 	Bucket string `json:"bucket"`
+	// LocalStore keeps shares in a local directory instead of S3.
+	LocalStore string `json:"local-store"`
+
+	// S3 compatible stores such as MinIO or Ceph
+	Endpoint  string `json:"endpoint"`
+	PathStyle bool   `json:"path-style"`
+	CABundle  string `json:"ca-bundle"`
+	Insecure  bool   `json:"insecure-skip-verify"`
+	SSE       string `json:"sse"`
+	// SSECustomerKeyFile holds the key for SSE-C.  The key itself is
+	// only ever read from the file or the environment.
+	SSECustomerKeyFile string `json:"sse-customer-key-file"`
+	// StorageClass of the objects uploaded, the bucket default if empty.
+	StorageClass string `json:"storage-class"`
+	// NoChecksums leaves out the SHA-256 checksum headers, for stores
+	// that do not support them.
+	NoChecksums bool `json:"no-checksums"`
+
+	// Transfer tuning.  PartSize is in MiB, Concurrency is the parts in
+	// flight per file and MaxConnections caps connections to the store.
+	PartSize       int64 `json:"part-size"`
+	Concurrency    int   `json:"concurrency"`
+	MaxConnections int   `json:"max-connections"`
+	// MaxBandwidth caps the bytes a second to and from the store across
+	// every transfer, such as 10MB or 20Mbit, and TransferWindows are
+	// the times of day transfers may run in, such as 19:00-06:00.
+	MaxBandwidth    string   `json:"max-bandwidth"`
+	TransferWindows []string `json:"transfer-windows"`
+
+	// Where the state of interrupted uploads is kept.
+	StateDir string `json:"state-dir"`
+	// WorkDir is where the files of a share are compressed and
+	// encrypted until they are uploaded, outside the directory being
+	// shared.  share gives every share a directory of its own in it.
+	WorkDir string `json:"work-dir"`
+
+	// Files in flight at once, and the workers that compress and
+	// encrypt them and the workers that transfer them.
+	Parallel   int `json:"parallel"`
+	CPUWorkers int `json:"cpu-workers"`
+	IOWorkers  int `json:"io-workers"`
+
+	// How often to retry a file that failed, and how long to wait
+	// before the first retry.
+	Retries   int           `json:"retries"`
+	RetryWait time.Duration `json:"retry-wait"`
 
 	// Encrypt only
-	PubKey    string `json:"pubkey"`
-	Directory string `json:"directory"`
-	AwsKey    string `json:"awskey"`
-	Org       string `json:"org"`
-	Prefix    string `json:"prefix"`
-	Hash      bool   `json:"hash"`
+	PubKey    string        `json:"pubkey"`
+	Directory string        `json:"directory"`
+	AwsKey    string        `json:"awskey"`
+	Org       string        `json:"org"`
+	Prefix    string        `json:"prefix"`
+	Hash      bool          `json:"hash"`
+	Include   []string      `json:"include"`
+	Exclude   []string      `json:"exclude"`
+	DryRun    bool          `json:"-"`
+	Since     string        `json:"-"`
+	Resume    string        `json:"-"`
+	Presign   time.Duration `json:"-"`
+	Ticket    string        `json:"-"`
+	// Preflight refuses to share into a bucket that fails doctor.
+	Preflight bool `json:"preflight"`
+	// Retention is how long the share should be kept.  It sets the
+	// expires tag on its objects.
+	Retention time.Duration `json:"retention"`
+
+	// Signing the manifest as the sender
+	SenderPubKey  string `json:"sender-public-key"`
+	SenderPrivKey string `json:"sender-private-key"`
+
+	// Classification labels for the share, labels for files by pattern
+	// and the rules that apply to labeled shares.
+	Labels     []string      `json:"labels"`
+	FileLabels []string      `json:"file-labels"`
+	Policy     []policy.Rule `json:"policy"`
 
 	// Decrypt only
 	File        string `json:"file"`
 	Destination string `json:"destination"`
 	PrivKey     string `json:"privkey"`
+	URL         string `json:"-"`
+	// NoAck skips writing an acknowledgement back into the share.
+	NoAck bool `json:"no-ack"`
 }
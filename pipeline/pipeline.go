@@ -0,0 +1,89 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+// Stage is one step every job goes through, such as compressing and
+// encrypting a file or uploading it.  Do is called with the index of
+// the job by up to Workers goroutines at once.
+type Stage struct {
+	Workers int
+	Do      func(i int) error
+}
+
+// Pipeline runs jobs through its stages in order.  Parallel caps the
+// jobs in flight across all stages, so a slow stage holds up the ones
+// before it instead of letting work, and temp files, pile up.
+type Pipeline struct {
+	Parallel int
+	Stages   []Stage
+}
+
+// Run sends jobs 0 to n-1 through the stages and returns the error,
+// if any, for each job.  A job that fails skips the rest of the stages.
+func (p Pipeline) Run(n int) []error {
+	errs := make([]error, n)
+	parallel := p.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+
+	in := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			slots <- struct{}{}
+			in <- i
+		}
+		close(in)
+	}()
+
+	jobs := in
+	for _, stage := range p.Stages {
+		jobs = p.start(stage, jobs, errs)
+	}
+	for range jobs {
+		<-slots
+	}
+	return errs
+}
+
+// start runs the workers for a stage and returns the channel the jobs
+// leave it on.  The channel is closed once every job has passed.
+func (p Pipeline) start(stage Stage, jobs <-chan int, errs []error) chan int {
+	workers := stage.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan int)
+	done := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				if errs[i] == nil {
+					errs[i] = stage.Do(i)
+				}
+				out <- i
+			}
+			done <- struct{}{}
+		}()
+	}
+	go func() {
+		for w := 0; w < workers; w++ {
+			<-done
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/pipeline"
+)
+
+// gauge tracks the most callers inside it at once.
+type gauge struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (g *gauge) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current++
+	if g.current > g.max {
+		g.max = g.current
+	}
+}
+
+func (g *gauge) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current--
+}
+
+var _ = Describe("Pipeline", func() {
+	It("should run every job through every stage", func() {
+		done := make([]int, 20)
+		p := pipeline.Pipeline{Parallel: 4, Stages: []pipeline.Stage{
+			{Workers: 2, Do: func(i int) error { done[i]++; return nil }},
+			{Workers: 3, Do: func(i int) error { done[i] += 10; return nil }},
+		}}
+		for _, err := range p.Run(len(done)) {
+			Expect(err).NotTo(HaveOccurred())
+		}
+		for _, d := range done {
+			Expect(d).To(Equal(11))
+		}
+	})
+
+	It("should limit the workers in each stage and the jobs in flight", func() {
+		var cpu, io, flight gauge
+		p := pipeline.Pipeline{Parallel: 3, Stages: []pipeline.Stage{
+			{Workers: 2, Do: func(i int) error {
+				flight.enter()
+				cpu.enter()
+				defer cpu.leave()
+				time.Sleep(time.Millisecond)
+				return nil
+			}},
+			{Workers: 1, Do: func(i int) error {
+				io.enter()
+				defer io.leave()
+				defer flight.leave()
+				time.Sleep(3 * time.Millisecond)
+				return nil
+			}},
+		}}
+		p.Run(12)
+		Expect(cpu.max).To(BeNumerically("<=", 2))
+		Expect(io.max).To(Equal(1))
+		Expect(flight.max).To(BeNumerically("<=", 3))
+	})
+
+	It("should skip the later stages for a failed job", func() {
+		uploaded := make([]bool, 3)
+		p := pipeline.Pipeline{Parallel: 2, Stages: []pipeline.Stage{
+			{Workers: 1, Do: func(i int) error {
+				if i == 1 {
+					return errors.New("unable to encrypt")
+				}
+				return nil
+			}},
+			{Workers: 1, Do: func(i int) error { uploaded[i] = true; return nil }},
+		}}
+		errs := p.Run(3)
+		Expect(errs[1]).To(HaveOccurred())
+		Expect(uploaded).To(Equal([]bool{true, false, true}))
+	})
+})
@@ -0,0 +1,174 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jemurai/s3s2/ignore"
+)
+
+// LabelFileName is the sidecar file at the root of a share that labels
+// files, one "pattern LABEL[,LABEL]" per line.
+const LabelFileName = ".s3s2labels"
+
+// Protections a rule can require.
+const (
+	KMS    = "kms"
+	GPG    = "gpg"
+	Signed = "signed"
+	Hashed = "hashed"
+)
+
+// FileLabel applies labels to the files matching a gitignore style pattern.
+type FileLabel struct {
+	Pattern string
+	Labels  []string
+}
+
+// Rule is a policy for shares carrying a label.  Require lists the
+// protections the share must have and DenyPrefix the share prefixes
+// it may not be sent to.
+type Rule struct {
+	Label      string   `json:"label" mapstructure:"label"`
+	Require    []string `json:"require,omitempty" mapstructure:"require"`
+	DenyPrefix []string `json:"deny-prefix,omitempty" mapstructure:"deny-prefix"`
+}
+
+// Protection describes how a share is protected and where it is going.
+type Protection struct {
+	KMS    bool
+	GPG    bool
+	Signed bool
+	Hashed bool
+	Prefix string
+}
+
+// ParseFileLabel parses "pattern=LABEL[,LABEL]" as given on the command line.
+func ParseFileLabel(s string) (FileLabel, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return FileLabel{}, fmt.Errorf("file label %q should look like pattern=LABEL", s)
+	}
+	return FileLabel{Pattern: s[:i], Labels: SplitLabels(s[i+1:])}, nil
+}
+
+// ReadLabelFile reads the sidecar label file.  A missing file is not an error.
+func ReadLabelFile(filename string) ([]FileLabel, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var labels []FileLabel
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: %q should look like: pattern LABEL[,LABEL]", filename, line)
+		}
+		labels = append(labels, FileLabel{Pattern: fields[0], Labels: SplitLabels(fields[1])})
+	}
+	return labels, scanner.Err()
+}
+
+// SplitLabels splits a comma separated list of labels.
+func SplitLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// LabelsFor returns the labels of every file label matching a path.
+func LabelsFor(path string, fileLabels []FileLabel) []string {
+	var labels []string
+	for _, fl := range fileLabels {
+		if ignore.Match(fl.Pattern, path, false) {
+			labels = append(labels, fl.Labels...)
+		}
+	}
+	return Union(labels)
+}
+
+// Union de-duplicates and sorts sets of labels.
+func Union(sets ...[]string) []string {
+	seen := make(map[string]bool)
+	var labels []string
+	for _, set := range sets {
+		for _, l := range set {
+			if !seen[l] {
+				seen[l] = true
+				labels = append(labels, l)
+			}
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Check the rules for the labels against how a share is protected and
+// return every violation.
+func Check(rules []Rule, labels []string, p Protection) []error {
+	var violations []error
+	has := make(map[string]bool)
+	for _, l := range labels {
+		has[strings.ToLower(l)] = true
+	}
+	for _, rule := range rules {
+		if !has[strings.ToLower(rule.Label)] {
+			continue
+		}
+		for _, req := range rule.Require {
+			if !p.has(req) {
+				violations = append(violations, fmt.Errorf("%s requires %s protection", rule.Label, req))
+			}
+		}
+		for _, prefix := range rule.DenyPrefix {
+			if strings.HasPrefix(p.Prefix, prefix) {
+				violations = append(violations, fmt.Errorf("%s cannot be shared to prefix %s", rule.Label, p.Prefix))
+			}
+		}
+	}
+	return violations
+}
+
+func (p Protection) has(protection string) bool {
+	switch strings.ToLower(protection) {
+	case KMS:
+		return p.KMS
+	case GPG:
+		return p.GPG
+	case Signed:
+		return p.Signed
+	case Hashed:
+		return p.Hashed
+	}
+	return false
+}
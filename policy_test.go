@@ -0,0 +1,43 @@
+package main_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/policy"
+)
+
+var _ = Describe("Policy", func() {
+	var (
+		rules []policy.Rule
+	)
+
+	BeforeEach(func() {
+		rules = []policy.Rule{
+			{Label: "PHI", Require: []string{policy.KMS, policy.GPG}},
+			{Label: "Confidential", DenyPrefix: []string{"public"}},
+		}
+	})
+
+	It("should label files by pattern", func() {
+		fl, err := policy.ParseFileLabel("patients/**=PHI,PII")
+		Expect(err).NotTo(HaveOccurred())
+		labels := []policy.FileLabel{fl, {Pattern: "*.csv", Labels: []string{"PII"}}}
+		Expect(policy.LabelsFor("patients/2019/a.csv", labels)).To(Equal([]string{"PHI", "PII"}))
+		Expect(policy.LabelsFor("other/b.txt", labels)).To(BeEmpty())
+	})
+
+	It("should require the protections for a label", func() {
+		Expect(policy.Check(rules, []string{"PHI"}, policy.Protection{KMS: true})).To(HaveLen(1))
+		Expect(policy.Check(rules, []string{"phi"}, policy.Protection{KMS: true, GPG: true})).To(BeEmpty())
+	})
+
+	It("should deny prefixes for a label", func() {
+		Expect(policy.Check(rules, []string{"Confidential"}, policy.Protection{Prefix: "public_x"})).To(HaveLen(1))
+		Expect(policy.Check(rules, []string{"Confidential"}, policy.Protection{Prefix: "partner"})).To(BeEmpty())
+	})
+
+	It("should ignore unlabeled shares", func() {
+		Expect(policy.Check(rules, nil, policy.Protection{})).To(BeEmpty())
+	})
+})
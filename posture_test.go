@@ -0,0 +1,127 @@
+package main_test
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	s3helper "github.com/jemurai/s3s2/s3"
+)
+
+var _ = Describe("Bucket posture", func() {
+	const tlsPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Sid": "TLSOnly",
+    "Effect": "Deny",
+    "Principal": "*",
+    "Action": "s3:*",
+    "Resource": ["arn:aws:s3:::incoming", "arn:aws:s3:::incoming/*"],
+    "Condition": {"Bool": {"aws:SecureTransport": "false"}}
+  }]
+}`
+
+	var safe s3helper.BucketConfig
+
+	BeforeEach(func() {
+		safe = s3helper.BucketConfig{
+			Bucket: "incoming",
+			PublicAccessBlock: map[string]bool{
+				"BlockPublicAcls": true, "IgnorePublicAcls": true,
+				"BlockPublicPolicy": true, "RestrictPublicBuckets": true,
+			},
+			Encryption:    "aws:kms",
+			EncryptionKey: "alias/s3s2",
+			Versioning:    "Enabled",
+			Policy:        tlsPolicy,
+			AwsKey:        "alias/s3s2",
+			KeyState:      "Enabled",
+			KeyUsage:      "ENCRYPT_DECRYPT",
+		}
+	})
+
+	statuses := func(c s3helper.BucketConfig) map[string]s3helper.Status {
+		found := map[string]s3helper.Status{}
+		for _, f := range s3helper.CheckPosture(c) {
+			found[f.Check] = f.Status
+		}
+		return found
+	}
+
+	It("should pass a bucket that is set up safely", func() {
+		for check, status := range statuses(safe) {
+			Expect(status).To(Equal(s3helper.Pass), check)
+		}
+	})
+
+	It("should fail a bucket that could be made public", func() {
+		safe.PublicAccessBlock = nil
+		safe.PublicGrants = []string{"AllUsers:READ"}
+		found := statuses(safe)
+		Expect(found["Block Public Access"]).To(Equal(s3helper.Fail))
+		Expect(found["Public ACLs"]).To(Equal(s3helper.Fail))
+
+		safe.PublicAccessBlock = map[string]bool{"BlockPublicAcls": true}
+		Expect(statuses(safe)["Block Public Access"]).To(Equal(s3helper.Warn))
+	})
+
+	It("should fail a bucket without default encryption or a TLS only policy", func() {
+		safe.Encryption, safe.EncryptionKey = "", ""
+		safe.Policy = ""
+		found := statuses(safe)
+		Expect(found["Default encryption"]).To(Equal(s3helper.Fail))
+		Expect(found["TLS only policy"]).To(Equal(s3helper.Fail))
+	})
+
+	It("should warn when versioning is off or a setting can not be read", func() {
+		safe.Versioning = ""
+		safe.ACLErr = awserr.New("AccessDenied", "Access Denied", nil)
+		found := statuses(safe)
+		Expect(found["Versioning"]).To(Equal(s3helper.Warn))
+		Expect(found["Public ACLs"]).To(Equal(s3helper.Warn))
+		for _, f := range s3helper.CheckPosture(safe) {
+			Expect(f.Unchecked).To(Equal(f.Check == "Public ACLs"), f.Check)
+		}
+	})
+
+	It("should fail a KMS key that is missing, disabled or not usable", func() {
+		missing := safe
+		missing.KeyErr = awserr.New("NotFoundException", "Alias not found", nil)
+		Expect(statuses(missing)["KMS key"]).To(Equal(s3helper.Fail))
+
+		disabled := safe
+		disabled.KeyState = "Disabled"
+		Expect(statuses(disabled)["KMS key"]).To(Equal(s3helper.Fail))
+
+		denied := safe
+		denied.KeyUseErr = awserr.New("AccessDeniedException", "not authorized to perform kms:GenerateDataKey", nil)
+		Expect(statuses(denied)["KMS key"]).To(Equal(s3helper.Fail))
+
+		unchecked := safe
+		unchecked.KeyErr = errors.New("KMS is not checked for S3 compatible stores")
+		Expect(statuses(unchecked)["KMS key"]).To(Equal(s3helper.Warn))
+	})
+
+	It("should recognize a policy that denies requests without TLS", func() {
+		Expect(s3helper.RequiresTLS(tlsPolicy, "incoming")).To(BeTrue())
+		Expect(s3helper.RequiresTLS(`{"Statement": {"Effect": "Deny", "Principal": {"AWS": "*"}, "Action": "*", "Resource": "*", "Condition": {"Bool": {"aws:SecureTransport": false}}}}`, "incoming")).To(BeTrue())
+		Expect(s3helper.RequiresTLS(`{"Statement": [{"Effect": "Allow", "Condition": {"Bool": {"aws:SecureTransport": "true"}}}]}`, "incoming")).To(BeFalse())
+		Expect(s3helper.RequiresTLS(`{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:DeleteBucket"}]}`, "incoming")).To(BeFalse())
+		Expect(s3helper.RequiresTLS("not json", "incoming")).To(BeFalse())
+	})
+
+	It("should only count a TLS policy that covers everyone, every action and the objects", func() {
+		Expect(s3helper.RequiresTLS(tlsPolicy, "other")).To(BeFalse())
+		for _, narrow := range []string{
+			`"Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "s3:*", "Resource": "arn:aws:s3:::incoming/*"`,
+			`"Principal": "*", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::incoming/*"`,
+			`"Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::incoming/private/*"`,
+			`"Principal": "*", "NotAction": "s3:GetObject", "Action": "s3:*", "Resource": "arn:aws:s3:::incoming/*"`,
+		} {
+			policy := `{"Statement": [{"Effect": "Deny", ` + narrow + `, "Condition": {"Bool": {"aws:SecureTransport": "false"}}}]}`
+			Expect(s3helper.RequiresTLS(policy, "incoming")).To(BeFalse(), narrow)
+		}
+	})
+})
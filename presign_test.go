@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/manifest"
+	"github.com/jemurai/s3s2/storage"
+)
+
+var _ = Describe("Presigned links", func() {
+	var (
+		dir    string
+		server *httptest.Server
+		links  map[string]string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-links")
+		os.MkdirAll(filepath.Join(dir, "share_1"), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(dir, "share_1", "a.csv.zip.gpg"), []byte("encrypted"), 0600)
+		files := http.FileServer(http.Dir(dir))
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("X-Amz-Signature") != "good" {
+				http.Error(w, "Request has expired", http.StatusForbidden)
+				return
+			}
+			files.ServeHTTP(w, r)
+		}))
+		links = map[string]string{
+			"share_1/a.csv.zip.gpg": server.URL + "/share_1/a.csv.zip.gpg?X-Amz-Signature=good",
+			"share_1/b.csv.zip.gpg": server.URL + "/share_1/b.csv.zip.gpg?X-Amz-Signature=good",
+			"share_1/c.csv.zip.gpg": server.URL + "/share_1/a.csv.zip.gpg?X-Amz-Signature=old",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should download objects through their links", func() {
+		store := storage.NewHTTP(nil, links)
+		data, err := storage.ReadObject(store, "share_1/a.csv.zip.gpg")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("encrypted"))
+
+		out := filepath.Join(dir, "out", "a.csv.zip.gpg")
+		Expect(storage.GetFile(store, "share_1/a.csv.zip.gpg", out)).To(Succeed())
+		data, _ = ioutil.ReadFile(out)
+		Expect(string(data)).To(Equal("encrypted"))
+
+		objects, _ := store.List("share_1/")
+		Expect(objects).To(HaveLen(3))
+	})
+
+	It("should explain links that do not work", func() {
+		store := storage.NewHTTP(nil, links)
+		_, err := store.Get("share_1/b.csv.zip.gpg")
+		Expect(err).To(MatchError(ContainSubstring(storage.ErrNotFound.Error())))
+		_, err = store.Get("share_1/c.csv.zip.gpg")
+		Expect(err).To(MatchError(ContainSubstring("expired")))
+		_, err = store.Get("share_1/d.csv.zip.gpg")
+		Expect(err).To(MatchError(ContainSubstring("no link")))
+	})
+
+	It("should be read only", func() {
+		store := storage.NewHTTP(nil, links)
+		_, err := storage.WriteObject(store, "share_1/x", []byte("x"), storage.PutOptions{})
+		Expect(err).To(Equal(storage.ErrReadOnly))
+		Expect(store.Delete("share_1/a.csv.zip.gpg")).To(Equal(storage.ErrReadOnly))
+	})
+
+	It("should need S3 to make links", func() {
+		_, err := storage.Presign(storage.NewLocal(dir), "share_1/a.csv.zip.gpg", time.Hour)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should leave the links out of the manifest digest", func() {
+		m := manifest.Manifest{Folder: "share_1", Files: []manifest.FileDescription{{Name: "a.csv"}}}
+		digest := manifest.Digest(m)
+		expires := time.Now()
+		m.Links = links
+		m.LinksExpire = &expires
+		Expect(manifest.Digest(m)).To(Equal(digest))
+	})
+})
@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/ack"
+	"github.com/jemurai/s3s2/manifest"
+)
+
+var _ = Describe("Purging shares", func() {
+	It("should pick shares past their expiry", func() {
+		now := time.Date(2019, 6, 30, 0, 0, 0, 0, time.UTC)
+		past, future := now.Add(-time.Hour), now.Add(time.Hour)
+		filter := manifest.Filter{ExpiredBy: now}
+		Expect(filter.Matches(manifest.Summary{Expires: &past})).To(BeTrue())
+		Expect(filter.Matches(manifest.Summary{Expires: &future})).To(BeFalse())
+		Expect(filter.Matches(manifest.Summary{})).To(BeFalse())
+	})
+
+	It("should record the earlier shares a share uses", func() {
+		m := manifest.Manifest{
+			Folder: "share_3",
+			Files: []manifest.FileDescription{
+				{Name: "/a.csv", Folder: "share_1"},
+				{Name: "/b.csv", Folder: "share_2"},
+				{Name: "/c.csv", Folder: "share_1"},
+				{Name: "/d.csv"},
+			},
+		}
+		Expect(manifest.Summarize("share_3/s3s2_manifest.json", m).Uses).To(Equal([]string{"share_1", "share_2"}))
+	})
+
+	It("should keep shares that a kept share still uses", func() {
+		all := []manifest.Summary{
+			{ShareID: "share_1"},
+			{ShareID: "share_2", Uses: []string{"share_1"}},
+			{ShareID: "share_3", Uses: []string{"share_2"}},
+			{ShareID: "share_4"},
+		}
+		used := manifest.StillUsed(all, map[string]bool{"share_1": true, "share_2": true, "share_4": true})
+		Expect(used).To(Equal(map[string]string{"share_2": "share_3", "share_1": "share_2"}))
+
+		used = manifest.StillUsed(all, map[string]bool{"share_1": true, "share_2": true, "share_3": true})
+		Expect(used).To(BeEmpty())
+	})
+
+	It("should recognize acknowledgements in a share folder", func() {
+		Expect(ack.Prefix("in/share_1")).To(Equal("in/share_1/s3s2_acks/"))
+		Expect(ack.IsKey("in/share_1/s3s2_acks/receiver.json")).To(BeTrue())
+		Expect(ack.IsKey("in/share_1/s3s2_acks.csv.zip.gpg")).To(BeFalse())
+	})
+
+	It("should only count a share as delivered when every file was", func() {
+		names := []string{"a.csv", "b.csv"}
+		partial := ack.Ack{Files: []ack.File{{Name: "a.csv", Status: ack.Verified}, {Name: "b.csv", Status: ack.Failed}}}
+		Expect(ack.Deliver(nil, names).Status).To(Equal(ack.NotRetrieved))
+		Expect(ack.Deliver([]ack.Ack{partial}, names).Status).To(Equal(ack.Partial))
+		retry := ack.Ack{Files: []ack.File{{Name: "b.csv", Status: ack.Received}}}
+		Expect(ack.Deliver([]ack.Ack{partial, retry}, names).Status).To(Equal(ack.Delivered))
+	})
+})
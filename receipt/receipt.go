@@ -0,0 +1,215 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package receipt
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"text/template"
+	"time"
+
+	manifest "github.com/jemurai/s3s2/manifest"
+)
+
+// Item is a single file in a receipt.
+type Item struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"sha256,omitempty"`
+	Key  string `json:"key"`
+	ETag string `json:"etag,omitempty"`
+}
+
+// Receipt is the record of a share for compliance: who sent what,
+// when, to whom and how it was protected.
+type Receipt struct {
+	ShareID      string    `json:"share_id"`
+	Organization string    `json:"organization"`
+	Sender       string    `json:"sender"`
+	SenderName   string    `json:"sender_name,omitempty"`
+	SudoUser     string    `json:"sudo_user,omitempty"`
+	Shared       time.Time `json:"shared"`
+	Bucket       string    `json:"bucket,omitempty"`
+	KMSKey       string    `json:"kms_key,omitempty"`
+	SSE          string    `json:"sse,omitempty"`
+	Recipients   []string  `json:"recipient_fingerprints,omitempty"`
+	Root         string    `json:"merkle_root,omitempty"`
+	Signer       string    `json:"signer_fingerprint,omitempty"`
+	Since        string    `json:"since,omitempty"`
+	Files        []Item    `json:"files"`
+	FileCount    int       `json:"file_count"`
+	TotalSize    int64     `json:"total_size"`
+	Generated    time.Time `json:"generated"`
+}
+
+// New builds a receipt from a manifest.
+func New(m manifest.Manifest) Receipt {
+	r := Receipt{
+		ShareID:      m.Folder,
+		Organization: m.Organization,
+		Sender:       m.User,
+		SenderName:   m.Username,
+		SudoUser:     m.SudoUser,
+		Shared:       m.Timestamp,
+		Bucket:       m.Bucket,
+		KMSKey:       m.AwsKey,
+		SSE:          m.SSE,
+		Recipients:   m.Recipients,
+		Root:         m.Root,
+		Signer:       m.Signer,
+		Since:        m.Since,
+		Generated:    time.Now().UTC(),
+	}
+	for _, f := range m.Files {
+		item := Item{
+			Name: manifest.CleanName(f.Name),
+			Size: f.Size,
+			Key:  f.Key,
+			ETag: f.ETag,
+		}
+		if manifest.Hashed(f) {
+			item.Hash = f.Hash
+		}
+		r.Files = append(r.Files, item)
+		r.TotalSize += f.Size
+	}
+	r.FileCount = len(r.Files)
+	return r
+}
+
+// Write the receipt in one of the formats: text, markdown, html or json.
+func (r Receipt) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(r, "", " ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "text", "":
+		return textReceipt.Execute(w, r)
+	case "markdown", "md":
+		return markdownReceipt.Execute(w, r)
+	case "html":
+		return htmlReceipt.Execute(w, r)
+	}
+	return fmt.Errorf("unknown receipt format %q", format)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+var funcs = map[string]interface{}{
+	"none": orNone,
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+var textReceipt = template.Must(template.New("text").Funcs(funcs).Parse(`S3S2 SHARE RECEIPT
+
+Share:        {{.ShareID}}
+Organization: {{.Organization}}
+Sent by:      {{.Sender}}{{if .SenderName}} ({{.SenderName}}){{end}}{{if .SudoUser}} via sudo as {{.SudoUser}}{{end}}
+Shared at:    {{time .Shared}}
+Bucket:       {{none .Bucket}}
+KMS key:      {{none .KMSKey}}
+{{- if .SSE}}
+Encryption:   {{.SSE}}{{end}}
+Recipients:   {{if .Recipients}}{{range $i, $r := .Recipients}}{{if $i}}, {{end}}{{$r}}{{end}}{{else}}none{{end}}
+Merkle root:  {{none .Root}}
+Signed by:    {{none .Signer}}
+{{- if .Since}}
+Builds on:    {{.Since}}{{end}}
+
+Files ({{.FileCount}}, {{.TotalSize}} bytes):
+{{range .Files}}
+  {{.Name}}
+    size:   {{.Size}}
+    sha256: {{none .Hash}}
+    object: {{none .Key}}
+    etag:   {{none .ETag}}
+{{end}}
+Receipt generated at {{time .Generated}}
+`))
+
+var markdownReceipt = template.Must(template.New("markdown").Funcs(funcs).Parse(`# S3S2 Share Receipt
+
+| | |
+|---|---|
+| Share | ` + "`{{.ShareID}}`" + ` |
+| Organization | {{.Organization}} |
+| Sent by | {{.Sender}}{{if .SenderName}} ({{.SenderName}}){{end}}{{if .SudoUser}} via sudo as {{.SudoUser}}{{end}} |
+| Shared at | {{time .Shared}} |
+| Bucket | {{none .Bucket}} |
+| KMS key | {{none .KMSKey}} |
+{{- if .SSE}}
+| Encryption | {{.SSE}} |{{end}}
+| Recipients | {{if .Recipients}}{{range $i, $r := .Recipients}}{{if $i}}, {{end}}` + "`{{$r}}`" + `{{end}}{{else}}none{{end}} |
+| Merkle root | {{none .Root}} |
+| Signed by | {{none .Signer}} |
+{{- if .Since}}
+| Builds on | {{.Since}} |{{end}}
+
+## Files ({{.FileCount}}, {{.TotalSize}} bytes)
+
+| File | Size | SHA-256 | Object | ETag |
+|---|---|---|---|---|
+{{range .Files}}| {{.Name}} | {{.Size}} | {{none .Hash}} | {{none .Key}} | {{none .ETag}} |
+{{end}}
+_Receipt generated at {{time .Generated}}_
+`))
+
+var htmlReceipt = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>S3S2 Share Receipt {{.ShareID}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.hash { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>S3S2 Share Receipt</h1>
+<table>
+<tr><th>Share</th><td>{{.ShareID}}</td></tr>
+<tr><th>Organization</th><td>{{.Organization}}</td></tr>
+<tr><th>Sent by</th><td>{{.Sender}}{{if .SenderName}} ({{.SenderName}}){{end}}{{if .SudoUser}} via sudo as {{.SudoUser}}{{end}}</td></tr>
+<tr><th>Shared at</th><td>{{time .Shared}}</td></tr>
+<tr><th>Bucket</th><td>{{none .Bucket}}</td></tr>
+<tr><th>KMS key</th><td>{{none .KMSKey}}</td></tr>
+{{if .SSE}}<tr><th>Encryption</th><td>{{.SSE}}</td></tr>{{end}}
+<tr><th>Recipients</th><td>{{if .Recipients}}{{range .Recipients}}{{.}}<br>{{end}}{{else}}none{{end}}</td></tr>
+<tr><th>Merkle root</th><td class="hash">{{none .Root}}</td></tr>
+<tr><th>Signed by</th><td>{{none .Signer}}</td></tr>
+{{if .Since}}<tr><th>Builds on</th><td>{{.Since}}</td></tr>{{end}}
+</table>
+<h2>Files ({{.FileCount}}, {{.TotalSize}} bytes)</h2>
+<table>
+<tr><th>File</th><th>Size</th><th>SHA-256</th><th>Object</th><th>ETag</th></tr>
+{{range .Files}}<tr><td>{{.Name}}</td><td>{{.Size}}</td><td class="hash">{{none .Hash}}</td><td>{{none .Key}}</td><td class="hash">{{none .ETag}}</td></tr>
+{{end}}</table>
+<p><em>Receipt generated at {{time .Generated}}</em></p>
+</body>
+</html>
+`))
@@ -0,0 +1,53 @@
+package main_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/manifest"
+	"github.com/jemurai/s3s2/receipt"
+)
+
+var _ = Describe("Receipt", func() {
+	var (
+		r receipt.Receipt
+	)
+
+	BeforeEach(func() {
+		r = receipt.New(manifest.Manifest{
+			Folder:       "jemurai_s3s2_abc",
+			Organization: "Jemurai",
+			User:         "mk",
+			Timestamp:    time.Now(),
+			AwsKey:       "alias/s3s2",
+			Recipients:   []string{"ABCDEF"},
+			Files: []manifest.FileDescription{
+				{Name: "/a.csv", Size: 10, Hash: "aaa", Key: "jemurai_s3s2_abc/a.csv.zip.gpg", ETag: "etag-a"},
+				{Name: "/b.csv", Size: 5, Hash: manifest.FakeHash, Key: "jemurai_s3s2_abc/b.csv.zip.gpg"},
+			},
+		})
+	})
+
+	It("should total the files", func() {
+		Expect(r.FileCount).To(Equal(2))
+		Expect(r.TotalSize).To(Equal(int64(15)))
+		Expect(r.Files[1].Hash).To(BeEmpty())
+	})
+
+	It("should write every format", func() {
+		for _, format := range []string{"text", "markdown", "html", "json"} {
+			var b bytes.Buffer
+			Expect(r.Write(&b, format)).To(Succeed())
+			Expect(b.String()).To(ContainSubstring("etag-a"))
+			Expect(b.String()).To(ContainSubstring("alias/s3s2"))
+		}
+	})
+
+	It("should reject unknown formats", func() {
+		var b bytes.Buffer
+		Expect(r.Write(&b, "pdf")).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,244 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resume
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// ErrChanged is returned by Load when the local file is not the one the
+// upload was started from.
+var ErrChanged = errors.New("file changed since the upload started")
+
+// Part is a part of a multipart upload that S3 has accepted.
+type Part struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Upload is the state of a multipart upload of a local file, enough to
+// pick it up again after a crash or a dropped connection.
+type Upload struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	UploadID string    `json:"upload_id"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	PartSize int64     `json:"part_size"`
+	Started  time.Time `json:"started"`
+	Parts    []Part    `json:"parts"`
+	// Checksums is set for uploads started with SHA-256 checksums,
+	// whose parts must each be sent with theirs.
+	Checksums bool `json:"checksums,omitempty"`
+
+	mu     sync.Mutex
+	saveMu sync.Mutex
+}
+
+// State keeps one file per upload in progress in a directory.
+type State struct {
+	dir string
+}
+
+// DefaultDir is where upload state is kept unless told otherwise.
+func DefaultDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return filepath.Join(".s3s2", "uploads")
+	}
+	return filepath.Join(home, ".s3s2", "uploads")
+}
+
+// Open keeps upload state in the directory, or the default one.
+func Open(dir string) *State {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	return &State{dir: dir}
+}
+
+// path names the state file for a local file going to a bucket.
+func (s *State) path(bucket string, file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	sum := sha256.Sum256([]byte(bucket + "\x00" + file))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load finds the upload in progress for a local file, if any.  It is
+// only returned while the file is the same size and age as when the
+// upload started, since the parts already sent came from that file.
+func (s *State) Load(bucket string, file string) (*Upload, error) {
+	data, err := ioutil.ReadFile(s.path(bucket, file))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(file)
+	if err != nil || stat.Size() != u.Size || !stat.ModTime().Equal(u.Modified) {
+		return &u, ErrChanged
+	}
+	return &u, nil
+}
+
+// Uploads lists every upload in progress.
+func (s *State) Uploads() ([]*Upload, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var uploads []*Upload
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var u Upload
+		if err := json.Unmarshal(data, &u); err != nil {
+			continue
+		}
+		uploads = append(uploads, &u)
+	}
+	return uploads, nil
+}
+
+// Save writes the state of an upload, replacing the old state file in
+// one step so a crash never leaves half of it behind.
+func (s *State) Save(u *Upload) error {
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+	u.mu.Lock()
+	data, err := json.MarshalIndent(u, "", "  ")
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(u.Bucket, u.File))
+}
+
+// Remove forgets an upload that has completed or been aborted.
+func (s *State) Remove(u *Upload) error {
+	err := os.Remove(s.path(u.Bucket, u.File))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// NewUpload starts tracking an upload of a local file.
+func NewUpload(bucket string, key string, uploadID string, file string, partSize int64) (*Upload, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Upload{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadID,
+		File:     file,
+		Size:     stat.Size(),
+		Modified: stat.ModTime(),
+		PartSize: partSize,
+		Started:  time.Now().UTC(),
+	}, nil
+}
+
+// PartCount is the number of parts the file is sent in.
+func (u *Upload) PartCount() int64 {
+	if u.Size == 0 {
+		return 1
+	}
+	return (u.Size + u.PartSize - 1) / u.PartSize
+}
+
+// Section is the part of the file for a part number, which count from 1.
+func (u *Upload) Section(f io.ReaderAt, number int64) *io.SectionReader {
+	offset := (number - 1) * u.PartSize
+	size := u.PartSize
+	if offset+size > u.Size {
+		size = u.Size - offset
+	}
+	return io.NewSectionReader(f, offset, size)
+}
+
+// Done records a part S3 has accepted.
+func (u *Upload) Done(p Part) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range u.Parts {
+		if u.Parts[i].Number == p.Number {
+			u.Parts[i] = p
+			return
+		}
+	}
+	u.Parts = append(u.Parts, p)
+	sort.Slice(u.Parts, func(i, j int) bool { return u.Parts[i].Number < u.Parts[j].Number })
+}
+
+// Completed returns the parts already sent, by part number.
+func (u *Upload) Completed() map[int64]Part {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	done := map[int64]Part{}
+	for _, p := range u.Parts {
+		done[p.Number] = p
+	}
+	return done
+}
+
+// Checksum is the hex SHA-256 of a part of the file.
+func Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
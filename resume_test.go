@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/manifest"
+	"github.com/jemurai/s3s2/options"
+	"github.com/jemurai/s3s2/resume"
+)
+
+var _ = Describe("Resumable uploads", func() {
+	var (
+		dir   string
+		file  string
+		state *resume.State
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-resume")
+		file = filepath.Join(dir, "big.zip.gpg")
+		ioutil.WriteFile(file, []byte(strings.Repeat("x", 25)), 0600)
+		state = resume.Open(filepath.Join(dir, "state"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should split the file into parts", func() {
+		u, err := resume.NewUpload("bucket", "share/big.zip.gpg", "id", file, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(u.PartCount()).To(Equal(int64(3)))
+
+		f, _ := os.Open(file)
+		defer f.Close()
+		Expect(u.Section(f, 1).Size()).To(Equal(int64(10)))
+		Expect(u.Section(f, 3).Size()).To(Equal(int64(5)))
+	})
+
+	It("should save and load the parts already sent", func() {
+		u, _ := resume.NewUpload("bucket", "share/big.zip.gpg", "id", file, 10)
+		u.Done(resume.Part{Number: 2, ETag: "b"})
+		u.Done(resume.Part{Number: 1, ETag: "a"})
+		u.Done(resume.Part{Number: 2, ETag: "c"})
+		Expect(state.Save(u)).To(Succeed())
+
+		loaded, err := state.Load("bucket", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded.UploadID).To(Equal("id"))
+		Expect(loaded.Parts).To(HaveLen(2))
+		Expect(loaded.Parts[0].ETag).To(Equal("a"))
+		Expect(loaded.Parts[1].ETag).To(Equal("c"))
+
+		uploads, _ := state.Uploads()
+		Expect(uploads).To(HaveLen(1))
+
+		Expect(state.Remove(loaded)).To(Succeed())
+		loaded, err = state.Load("bucket", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(BeNil())
+	})
+
+	It("should not resume from a file that changed", func() {
+		u, _ := resume.NewUpload("bucket", "share/big.zip.gpg", "id", file, 10)
+		state.Save(u)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(file, later, later)
+
+		_, err := state.Load("bucket", file)
+		Expect(err).To(Equal(resume.ErrChanged))
+	})
+
+	It("should keep uploads to other buckets apart", func() {
+		u, _ := resume.NewUpload("bucket", "share/big.zip.gpg", "id", file, 10)
+		state.Save(u)
+		loaded, err := state.Load("other", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(BeNil())
+	})
+
+	Describe("Shares", func() {
+		It("should keep files pending until they are uploaded", func() {
+			m := manifest.Manifest{
+				Files:   []manifest.FileDescription{{Name: "/a.csv"}, {Name: "/b.csv"}},
+				Pending: []string{"/a.csv", "/b.csv"},
+			}
+			m.Uploaded("/a.csv", "share/a.csv.zip.gpg", "etag")
+			Expect(m.Complete()).To(BeFalse())
+			Expect(m.Pending).To(Equal([]string{"/b.csv"}))
+			m.Uploaded("/b.csv", "share/b.csv.zip.gpg", "etag")
+			Expect(m.Complete()).To(BeTrue())
+		})
+
+		It("should share zip files next to the files they were made from", func() {
+			src := dir + "/src/"
+			os.MkdirAll(src, os.ModePerm)
+			ioutil.WriteFile(src+"data.csv", []byte("1,2"), 0600)
+			ioutil.WriteFile(src+"data.csv.zip", []byte("zip"), 0600)
+			ioutil.WriteFile(src+"data.csv.zip.gpg", []byte("gpg"), 0600)
+			ioutil.WriteFile(src+"other.zip", []byte("a real zip"), 0600)
+
+			files, _, err := manifest.ScanDirectory(options.Options{Directory: src})
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, f := range files {
+				names = append(names, f.Name)
+			}
+			Expect(names).To(ConsistOf("data.csv", "data.csv.zip", "data.csv.zip.gpg", "other.zip"))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Policy says how often and how patiently to retry a failed step.
+// Retries counts the attempts after the first one.  The wait before
+// each retry doubles from Wait up to MaxWait, and a random part of it
+// is used so that files that failed together do not retry together.
+type Policy struct {
+	Retries int
+	Wait    time.Duration
+	MaxWait time.Duration
+}
+
+// Backoff is how long to wait before a retry, counting from 1.
+func (p Policy) Backoff(retry int) time.Duration {
+	ceiling := p.Wait
+	for i := 1; i < retry && (p.MaxWait == 0 || ceiling < p.MaxWait); i++ {
+		ceiling *= 2
+	}
+	if p.MaxWait > 0 && ceiling > p.MaxWait {
+		ceiling = p.MaxWait
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	// Full jitter: anywhere from nothing up to the ceiling.
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do calls fn until it succeeds or the retries run out, and returns
+// the last error.
+func (p Policy) Do(what string, fn func() error) error {
+	err := fn()
+	for retry := 1; err != nil && retry <= p.Retries; retry++ {
+		wait := p.Backoff(retry)
+		log.Warnf("%s failed, retry %d of %d in %s: %v", what, retry, p.Retries, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+		err = fn()
+	}
+	return err
+}
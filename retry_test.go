@@ -0,0 +1,48 @@
+package main_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/retry"
+)
+
+var _ = Describe("Retry", func() {
+	It("should back off exponentially up to the limit", func() {
+		p := retry.Policy{Wait: 10 * time.Millisecond, MaxWait: 40 * time.Millisecond}
+		for i := 0; i < 50; i++ {
+			Expect(p.Backoff(1)).To(BeNumerically("<=", 10*time.Millisecond))
+			Expect(p.Backoff(2)).To(BeNumerically("<=", 20*time.Millisecond))
+			Expect(p.Backoff(10)).To(BeNumerically("<=", 40*time.Millisecond))
+			Expect(p.Backoff(10)).To(BeNumerically(">=", 0))
+		}
+	})
+
+	It("should retry until it succeeds", func() {
+		calls := 0
+		p := retry.Policy{Retries: 3, Wait: time.Millisecond}
+		err := p.Do("upload", func() error {
+			calls++
+			if calls < 3 {
+				return errors.New("connection reset")
+			}
+			return nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should give up after the retries", func() {
+		calls := 0
+		p := retry.Policy{Retries: 2, Wait: time.Millisecond}
+		err := p.Do("upload", func() error {
+			calls++
+			return errors.New("connection reset")
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(3))
+	})
+})
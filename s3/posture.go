@@ -0,0 +1,382 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Status is how a posture check came out.
+type Status string
+
+// The statuses of a posture check.  A warning is worth fixing but does
+// not put shares at risk by itself.
+const (
+	Pass Status = "PASS"
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+)
+
+// Finding is the outcome of one check of the bucket or KMS key.
+type Finding struct {
+	Check  string
+	Status Status
+	Detail string
+	// Unchecked is set when the setting could not be read, so the
+	// warning says nothing about whether it is safe.
+	Unchecked bool
+}
+
+// PublicAccessBlocks are the Block Public Access settings, all of which
+// should be on.
+var PublicAccessBlocks = []string{"BlockPublicAcls", "IgnorePublicAcls", "BlockPublicPolicy", "RestrictPublicBuckets"}
+
+// BucketConfig is the security configuration of a bucket and of the KMS
+// key shares are encrypted with, as far as it could be read.  Each part
+// that could not be read has its error set.
+type BucketConfig struct {
+	Bucket string
+	// PublicAccessBlock is nil when Block Public Access is not set up.
+	PublicAccessBlock    map[string]bool
+	PublicAccessBlockErr error
+	// Encryption is the default encryption algorithm, if any.
+	Encryption    string
+	EncryptionKey string
+	EncryptionErr error
+	Versioning    string
+	VersioningErr error
+	Policy        string
+	PolicyErr     error
+	// PublicGrants are the ACL grants to everyone or to any AWS user.
+	PublicGrants []string
+	ACLErr       error
+
+	// AwsKey is the KMS key shares are encrypted with, if any.
+	AwsKey   string
+	KeyState string
+	KeyUsage string
+	KeyErr   error
+	// KeyUseErr is set if the caller can not make data keys with it,
+	// which S3 needs to encrypt objects with the key.
+	KeyUseErr error
+}
+
+// BucketConfig reads the security configuration of the bucket and of
+// the KMS key, if one is given.
+func (s *Store) BucketConfig(awsKey string) BucketConfig {
+	c := BucketConfig{Bucket: s.options.Bucket, AwsKey: awsKey}
+	bucket := aws.String(s.options.Bucket)
+
+	pab, err := s.svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	if err == nil && pab.PublicAccessBlockConfiguration != nil {
+		conf := pab.PublicAccessBlockConfiguration
+		c.PublicAccessBlock = map[string]bool{
+			"BlockPublicAcls":       aws.BoolValue(conf.BlockPublicAcls),
+			"IgnorePublicAcls":      aws.BoolValue(conf.IgnorePublicAcls),
+			"BlockPublicPolicy":     aws.BoolValue(conf.BlockPublicPolicy),
+			"RestrictPublicBuckets": aws.BoolValue(conf.RestrictPublicBuckets),
+		}
+	} else if !hasCode(err, "NoSuchPublicAccessBlockConfiguration") {
+		c.PublicAccessBlockErr = err
+	}
+
+	enc, err := s.svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: bucket})
+	if err == nil && enc.ServerSideEncryptionConfiguration != nil {
+		for _, rule := range enc.ServerSideEncryptionConfiguration.Rules {
+			if d := rule.ApplyServerSideEncryptionByDefault; d != nil {
+				c.Encryption = aws.StringValue(d.SSEAlgorithm)
+				c.EncryptionKey = aws.StringValue(d.KMSMasterKeyID)
+			}
+		}
+	} else if !hasCode(err, "ServerSideEncryptionConfigurationNotFoundError") {
+		c.EncryptionErr = err
+	}
+
+	ver, err := s.svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: bucket})
+	if err == nil {
+		c.Versioning = aws.StringValue(ver.Status)
+	} else {
+		c.VersioningErr = err
+	}
+
+	policy, err := s.svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: bucket})
+	if err == nil {
+		c.Policy = aws.StringValue(policy.Policy)
+	} else if !hasCode(err, "NoSuchBucketPolicy") {
+		c.PolicyErr = err
+	}
+
+	acl, err := s.svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: bucket})
+	if err == nil {
+		for _, g := range acl.Grants {
+			if g.Grantee == nil {
+				continue
+			}
+			uri := aws.StringValue(g.Grantee.URI)
+			if strings.HasSuffix(uri, "/AllUsers") || strings.HasSuffix(uri, "/AuthenticatedUsers") {
+				c.PublicGrants = append(c.PublicGrants, uri[strings.LastIndex(uri, "/")+1:]+":"+aws.StringValue(g.Permission))
+			}
+		}
+	} else {
+		c.ACLErr = err
+	}
+
+	if awsKey != "" {
+		s.keyConfig(&c)
+	}
+	return c
+}
+
+// keyConfig reads the state of the KMS key and tries to use it.
+func (s *Store) keyConfig(c *BucketConfig) {
+	if s.options.Endpoint != "" {
+		c.KeyErr = errors.New("KMS is not checked for S3 compatible stores")
+		return
+	}
+	// The session may point at an S3 endpoint, so reset it for KMS.
+	svc := kms.New(s.sess, &aws.Config{Endpoint: aws.String("")})
+	key, err := svc.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(c.AwsKey)})
+	if err != nil {
+		c.KeyErr = err
+		return
+	}
+	c.KeyState = aws.StringValue(key.KeyMetadata.KeyState)
+	c.KeyUsage = aws.StringValue(key.KeyMetadata.KeyUsage)
+	_, c.KeyUseErr = svc.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(c.AwsKey),
+		KeySpec: aws.String("AES_256"),
+	})
+}
+
+// CheckPosture decides whether a bucket is set up safely enough to
+// share files in.
+func CheckPosture(c BucketConfig) []Finding {
+	var findings []Finding
+	add := func(check string, status Status, format string, args ...interface{}) {
+		findings = append(findings, Finding{Check: check, Status: status, Detail: fmt.Sprintf(format, args...)})
+	}
+	unchecked := func(check string, err error) {
+		add(check, Warn, "unable to check, %s", errorCode(err))
+		findings[len(findings)-1].Unchecked = true
+	}
+
+	const bpa = "Block Public Access"
+	switch {
+	case c.PublicAccessBlockErr != nil:
+		unchecked(bpa, c.PublicAccessBlockErr)
+	case c.PublicAccessBlock == nil:
+		add(bpa, Fail, "not configured for the bucket")
+	default:
+		var off []string
+		for _, name := range PublicAccessBlocks {
+			if !c.PublicAccessBlock[name] {
+				off = append(off, name)
+			}
+		}
+		if len(off) == len(PublicAccessBlocks) {
+			add(bpa, Fail, "every setting is off")
+		} else if len(off) > 0 {
+			add(bpa, Warn, "%s off", strings.Join(off, ", "))
+		} else {
+			add(bpa, Pass, "all settings on")
+		}
+	}
+
+	const enc = "Default encryption"
+	switch {
+	case c.EncryptionErr != nil:
+		unchecked(enc, c.EncryptionErr)
+	case c.Encryption == "":
+		add(enc, Fail, "objects are not encrypted unless the upload asks")
+	case c.EncryptionKey != "":
+		add(enc, Pass, "%s with %s", c.Encryption, c.EncryptionKey)
+	default:
+		add(enc, Pass, c.Encryption)
+	}
+
+	const ver = "Versioning"
+	switch {
+	case c.VersioningErr != nil:
+		unchecked(ver, c.VersioningErr)
+	case c.Versioning == "Enabled":
+		add(ver, Pass, "enabled")
+	case c.Versioning == "Suspended":
+		add(ver, Warn, "suspended, overwritten or deleted objects can not be recovered")
+	default:
+		add(ver, Warn, "off, overwritten or deleted objects can not be recovered")
+	}
+
+	const tls = "TLS only policy"
+	switch {
+	case c.PolicyErr != nil:
+		unchecked(tls, c.PolicyErr)
+	case c.Policy == "":
+		add(tls, Fail, "the bucket has no policy, so plain HTTP requests are allowed")
+	case !RequiresTLS(c.Policy, c.Bucket):
+		add(tls, Fail, "the bucket policy does not deny every request to the objects where aws:SecureTransport is false")
+	default:
+		add(tls, Pass, "requests without TLS are denied")
+	}
+
+	const acl = "Public ACLs"
+	switch {
+	case c.ACLErr != nil:
+		unchecked(acl, c.ACLErr)
+	case len(c.PublicGrants) > 0:
+		add(acl, Fail, "the bucket ACL grants %s", strings.Join(c.PublicGrants, ", "))
+	default:
+		add(acl, Pass, "no grants to everyone or to any AWS user")
+	}
+
+	const key = "KMS key"
+	switch {
+	case c.AwsKey == "":
+		add(key, Warn, "no --awskey, objects rely on the default encryption and GPG")
+	case c.KeyErr != nil && hasCode(c.KeyErr, "NotFoundException"):
+		add(key, Fail, "%s does not exist", c.AwsKey)
+	case c.KeyErr != nil && hasCode(c.KeyErr, "AccessDeniedException"):
+		add(key, Fail, "%s can not be described by the caller", c.AwsKey)
+	case c.KeyErr != nil:
+		unchecked(key, c.KeyErr)
+	case c.KeyState != "Enabled":
+		add(key, Fail, "%s is %s", c.AwsKey, strings.ToLower(c.KeyState))
+	case c.KeyUsage != "" && c.KeyUsage != "ENCRYPT_DECRYPT":
+		add(key, Fail, "%s is for %s, not encryption", c.AwsKey, c.KeyUsage)
+	case c.KeyUseErr != nil:
+		add(key, Fail, "%s can not be used by the caller, %s", c.AwsKey, errorCode(c.KeyUseErr))
+	default:
+		add(key, Pass, "%s is enabled and usable", c.AwsKey)
+	}
+	return findings
+}
+
+// RequiresTLS reports whether a bucket policy denies requests that are
+// not made over TLS: a Deny statement for every principal and every
+// action, on the objects of the bucket.
+func RequiresTLS(policy string, bucket string) bool {
+	var doc struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return false
+	}
+	type statement struct {
+		Effect    string
+		Principal interface{}
+		Action    interface{}
+		NotAction interface{}
+		Resource  interface{}
+		Condition map[string]map[string]interface{}
+	}
+	var statements []statement
+	if err := json.Unmarshal(doc.Statement, &statements); err != nil {
+		var single statement
+		if err := json.Unmarshal(doc.Statement, &single); err != nil {
+			return false
+		}
+		statements = []statement{single}
+	}
+	for _, st := range statements {
+		if st.Effect != "Deny" || st.NotAction != nil {
+			continue
+		}
+		if !everyone(st.Principal) || !hasValue(st.Action, "*", "s3:*") {
+			continue
+		}
+		if !hasValue(st.Resource, "*", "arn:aws:s3:::*", "arn:aws:s3:::"+bucket+"/*") {
+			continue
+		}
+		for operator, conditions := range st.Condition {
+			if operator != "Bool" && operator != "BoolIfExists" {
+				continue
+			}
+			for name, value := range conditions {
+				if strings.EqualFold(name, "aws:SecureTransport") && isFalse(value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// everyone reports whether a policy principal is anyone at all, written
+// as "*" or as {"AWS": "*"}.
+func everyone(principal interface{}) bool {
+	if p, ok := principal.(map[string]interface{}); ok {
+		return hasValue(p["AWS"], "*")
+	}
+	return hasValue(principal, "*")
+}
+
+// hasValue reports whether a policy value, a string or a list of them,
+// includes one of the wanted values.
+func hasValue(value interface{}, wanted ...string) bool {
+	switch v := value.(type) {
+	case string:
+		for _, w := range wanted {
+			if strings.EqualFold(v, w) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasValue(item, wanted...) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isFalse reports whether a policy condition value is false, which may
+// be written as a string, a boolean or a list of either.
+func isFalse(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return !v
+	case string:
+		return strings.EqualFold(v, "false")
+	case []interface{}:
+		for _, item := range v {
+			if isFalse(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasCode reports whether an error from AWS has the code.
+func hasCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+// errorCode is the AWS error code of an error, or the error itself.
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return err.Error()
+}
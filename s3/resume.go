@@ -0,0 +1,360 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	resume "github.com/jemurai/s3s2/resume"
+	storage "github.com/jemurai/s3s2/storage"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MultipartUpload is an upload that was started in the bucket but never
+// completed or aborted.
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// PutFile uploads a local file.  Files bigger than a part are sent as a
+// multipart upload whose state is saved after every part, so running
+// the same share again picks up where a crash or dropped connection
+// left off instead of starting over.
+func (s *Store) PutFile(key string, filename string, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	partSize := PartSize(s.options)
+	if stat.Size() <= partSize {
+		checksum, err := storage.Checksum(filename, 0)
+		if err != nil {
+			return storage.ObjectInfo{}, err
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			return storage.ObjectInfo{}, err
+		}
+		defer f.Close()
+		return s.put(key, f, opts, checksum)
+	}
+
+	u, err := s.startUpload(key, filename, partSize, opts)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	if err := s.sendParts(u); err != nil {
+		// Keep the state, the next run resumes from here.
+		return storage.ObjectInfo{}, err
+	}
+	return s.completeUpload(u)
+}
+
+// Resumable reports whether an interrupted upload of the file can be
+// resumed.
+func (s *Store) Resumable(filename string) bool {
+	u, err := s.state.Load(s.options.Bucket, filename)
+	return err == nil && u != nil
+}
+
+// startUpload resumes the upload of a file if one is in progress to the
+// same key and still exists in the bucket, and starts a new one otherwise.
+func (s *Store) startUpload(key string, filename string, partSize int64, opts storage.PutOptions) (*resume.Upload, error) {
+	u, err := s.state.Load(s.options.Bucket, filename)
+	if err == nil && u != nil && u.Key != key {
+		log.Infof("%s was being uploaded to %s, not %s, starting over.", filename, u.Key, key)
+		s.AbortUpload(u.Key, u.UploadID)
+		s.state.Remove(u)
+		u = nil
+	} else if err == resume.ErrChanged || (u != nil && u.PartSize != partSize) {
+		log.Infof("%s changed since its upload started, starting over.", filename)
+		s.AbortUpload(u.Key, u.UploadID)
+		s.state.Remove(u)
+		u = nil
+	} else if err != nil {
+		return nil, err
+	} else if u != nil && u.Checksums && s.options.NoChecksums {
+		log.Infof("%s was being uploaded with checksums, starting over without them.", filename)
+		s.AbortUpload(u.Key, u.UploadID)
+		s.state.Remove(u)
+		u = nil
+	}
+	if u != nil {
+		if err := s.listParts(u); err != nil {
+			log.Warnf("Unable to resume %s, starting over, %v", filename, err)
+			s.state.Remove(u)
+		} else {
+			log.Infof("Resuming upload of %s, %d of %d parts already sent.", filename, len(u.Parts), u.PartCount())
+			return u, nil
+		}
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(Tagging(opts.Tags))
+	}
+	input.Metadata = aws.StringMap(opts.Metadata)
+	input.StorageClass = s.storageClass()
+	if !s.options.NoChecksums {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	result, err := s.svc.CreateMultipartUpload(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload of %s, %v", key, err)
+	}
+	u, err = resume.NewUpload(s.options.Bucket, key, aws.StringValue(result.UploadId), filename, partSize)
+	if err != nil {
+		return nil, err
+	}
+	u.Checksums = !s.options.NoChecksums
+	return u, s.state.Save(u)
+}
+
+// listParts keeps only the parts in the saved state that the bucket
+// still has, with the same ETag.
+func (s *Store) listParts(u *resume.Upload) error {
+	listed := map[int64]string{}
+	err := s.svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(u.Bucket),
+		Key:      aws.String(u.Key),
+		UploadId: aws.String(u.UploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			listed[aws.Int64Value(p.PartNumber)] = strings.Trim(aws.StringValue(p.ETag), `"`)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var parts []resume.Part
+	for _, p := range u.Parts {
+		if listed[p.Number] == p.ETag {
+			parts = append(parts, p)
+		}
+	}
+	u.Parts = parts
+	return nil
+}
+
+// sendParts uploads the parts that are not done yet, a few at a time.
+// A part already sent is skipped if its checksum still matches the file.
+func (s *Store) sendParts(u *resume.Upload) error {
+	f, err := os.Open(u.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	concurrency := s.options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	done := u.Completed()
+	numbers := make(chan int64)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed error
+	)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				if err := s.sendPart(f, u, n, done[n]); err != nil {
+					mu.Lock()
+					if failed == nil {
+						failed = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for n := int64(1); n <= u.PartCount(); n++ {
+		mu.Lock()
+		stop := failed != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		numbers <- n
+	}
+	close(numbers)
+	wg.Wait()
+	return failed
+}
+
+func (s *Store) sendPart(f io.ReaderAt, u *resume.Upload, n int64, previous resume.Part) error {
+	section := u.Section(f, n)
+	sum, err := resume.Checksum(section)
+	if err != nil {
+		return err
+	}
+	if previous.ETag != "" && previous.SHA256 == sum {
+		return nil
+	}
+	s.limiter.Pause()
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// The part stops if the transfer window closes while it is sent.
+	// The reader keeps the section's io.Seeker.
+	body := s.limiter.Reader(section).(io.ReadSeeker)
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(u.Bucket),
+		Key:           aws.String(u.Key),
+		UploadId:      aws.String(u.UploadID),
+		PartNumber:    aws.Int64(n),
+		Body:          body,
+		ContentLength: aws.Int64(section.Size()),
+	}
+	// Every part of an SSE-C upload must carry the same key.
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	if u.Checksums {
+		// S3 refuses the part if it does not match.
+		input.ChecksumSHA256 = aws.String(partChecksum(sum))
+	}
+	result, err := s.svc.UploadPart(input)
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d of %s, %v", n, u.File, err)
+	}
+	u.Done(resume.Part{
+		Number: n,
+		ETag:   strings.Trim(aws.StringValue(result.ETag), `"`),
+		Size:   section.Size(),
+		SHA256: sum,
+	})
+	return s.state.Save(u)
+}
+
+// completeUpload asks the bucket to assemble the parts and forgets the
+// upload's state.  The object's checksum is worked out from the parts
+// sent, and with checksums S3 must agree with it.
+func (s *Store) completeUpload(u *resume.Upload) (storage.ObjectInfo, error) {
+	var parts []*s3.CompletedPart
+	var sums [][]byte
+	for _, p := range u.Parts {
+		part := &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(p.Number),
+		}
+		if u.Checksums {
+			part.ChecksumSHA256 = aws.String(partChecksum(p.SHA256))
+		}
+		if sum, _ := hex.DecodeString(p.SHA256); len(sum) > 0 {
+			sums = append(sums, sum)
+		}
+		parts = append(parts, part)
+	}
+	checksum := ""
+	if len(sums) == len(parts) {
+		checksum = storage.CompositeChecksum(sums)
+	}
+	result, err := s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.Bucket),
+		Key:             aws.String(u.Key),
+		UploadId:        aws.String(u.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to complete upload of %s, %v", u.Key, err)
+	}
+	if got := aws.StringValue(result.ChecksumSHA256); checksum != "" && got != "" && got != checksum {
+		return storage.ObjectInfo{}, fmt.Errorf("upload of %s has checksum %s, not %s", u.Key, got, checksum)
+	}
+	if err := s.state.Remove(u); err != nil {
+		log.Warnf("Unable to remove the upload state for %s, %v", u.File, err)
+	}
+	log.Debugf("\tFile uploaded to, %s\n", aws.StringValue(result.Location))
+	info := storage.ObjectInfo{
+		Key:      u.Key,
+		Size:     u.Size,
+		ETag:     strings.Trim(aws.StringValue(result.ETag), `"`),
+		Checksum: checksum,
+	}
+	if checksum != "" {
+		info.PartSize = u.PartSize
+	}
+	return info, nil
+}
+
+// partChecksum is the hex SHA-256 of a part the way S3 takes it.
+func partChecksum(sum string) string {
+	raw, _ := hex.DecodeString(sum)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// MultipartUploads lists the uploads in progress under a prefix.
+func (s *Store) MultipartUploads(prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	err := s.svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.options.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, u := range page.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.StringValue(u.Key),
+				UploadID:  aws.StringValue(u.UploadId),
+				Initiated: aws.TimeValue(u.Initiated),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads under %s, %v", prefix, err)
+	}
+	return uploads, nil
+}
+
+// AbortUpload throws away an upload in progress and the parts sent so
+// far.  An upload that is already gone is not an error.
+func (s *Store) AbortUpload(key string, uploadID string) error {
+	_, err := s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.options.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to abort upload of %s, %v", key, err)
+	}
+	return nil
+}
+
+// State is where the store keeps the uploads it can resume.
+func (s *Store) State() *resume.State {
+	return s.state
+}
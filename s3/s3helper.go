@@ -15,96 +15,548 @@
 package s3
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	options "github.com/jemurai/s3s2/options"
+	resume "github.com/jemurai/s3s2/resume"
+	storage "github.com/jemurai/s3s2/storage"
+	throttle "github.com/jemurai/s3s2/throttle"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-// UploadFile to S3.
-// If the key is present, use it.  If it is not, don't.
-// The share command should only allow this to get called
+// Store keeps shares in an S3 bucket.
+// If the AWS key is present, objects are encrypted with it at the
+// bucket.  The share command should only allow this to get used
 // IFF there is a key or the file has been gpg encrypted
 // for the receiver.
-func UploadFile(folder string, filename string, options options.Options) error {
-	log.Debugf("\tUploading file.")
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(options.Region),
-	}))
+type Store struct {
+	options    options.Options
+	sess       *session.Session
+	svc        *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+	state      *resume.State
+	// sseKey is the SSE-C key, if objects are encrypted with one.
+	sseKey []byte
+	// limiter holds parts back outside the transfer windows.
+	limiter *throttle.Limiter
+}
+
+// bufferSize is the size of the pooled buffers used to copy parts.
+const bufferSize = 1024 * 1024
 
-	uploader := s3manager.NewUploader(sess)
+// Sessions are expensive to build, mostly because of the credential
+// lookups, so stores with the same connection settings share one.
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*session.Session{}
+)
 
-	f, err := os.Open(filename)
+// NewStore builds a Store for the bucket in the options.  The endpoint
+// settings let it talk to S3 compatible stores such as MinIO, Ceph or
+// Wasabi as well as AWS.  One uploader and downloader are shared by
+// every file so the part size, concurrency and buffers apply across
+// the whole share.
+func NewStore(options options.Options) (*Store, error) {
+	sess, err := Session(options)
 	if err != nil {
-		return fmt.Errorf("failed to open file %q, %v", filename, err)
+		return nil, err
 	}
+	if err := CheckServerSideEncryption(options); err != nil {
+		return nil, err
+	}
+	sseKey, err := CustomerKey(options)
+	if err != nil {
+		return nil, err
+	}
+	if ServerSideEncryption(options) != SSECustomer {
+		// The key is only sent for objects encrypted with it.
+		sseKey = nil
+	}
+	if err := checkStorageClass(options.StorageClass); err != nil {
+		return nil, err
+	}
+	limiter, err := Limiter(options)
+	if err != nil {
+		return nil, err
+	}
+	partSize := PartSize(options)
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+	return &Store{
+		options: options,
+		sess:    sess,
+		svc:     s3.New(sess),
+		uploader: s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+			u.PartSize = partSize
+			u.Concurrency = concurrency
+			u.BufferProvider = s3manager.NewBufferedReadSeekerWriteToPool(bufferSize)
+		}),
+		downloader: s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+			d.PartSize = partSize
+			d.Concurrency = concurrency
+			d.BufferProvider = s3manager.NewPooledBufferedWriterReadFromProvider(bufferSize)
+		}),
+		state:   resume.Open(options.StateDir),
+		sseKey:  sseKey,
+		limiter: limiter,
+	}, nil
+}
 
-	if options.AwsKey != "" {
-		result, err := uploader.Upload(&s3manager.UploadInput{
-			Bucket:               aws.String(options.Bucket),
-			Key:                  aws.String(filepath.Clean(folder + "/" + strings.Replace(f.Name(), options.Directory, "", -1))),
-			ServerSideEncryption: aws.String("aws:kms"),
-			SSEKMSKeyId:          aws.String(options.AwsKey),
-			Body:                 f,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to upload file, %v", err)
+// Session returns the shared session for the connection settings in
+// the options, building it the first time it is asked for.
+func Session(options options.Options) (*session.Session, error) {
+	key := fmt.Sprintf("%s|%s|%t|%s|%t|%d|%s", options.Region, options.Endpoint, options.PathStyle,
+		options.CABundle, options.Insecure, options.MaxConnections, options.MaxBandwidth)
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if sess, ok := sessions[key]; ok {
+		return sess, nil
+	}
+	config, err := Config(options)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start an S3 session, %v", err)
+	}
+	sessions[key] = sess
+	return sess, nil
+}
+
+// PartSize is the multipart part size in bytes, which S3 requires to
+// be at least 5 MiB.
+func PartSize(options options.Options) int64 {
+	size := options.PartSize * 1024 * 1024
+	if size < s3manager.MinUploadPartSize {
+		size = s3manager.MinUploadPartSize
+	}
+	return size
+}
+
+// Config builds the AWS configuration for the region, endpoint and
+// TLS settings in the options.
+func Config(options options.Options) (*aws.Config, error) {
+	region := options.Region
+	if region == "" && options.Endpoint != "" {
+		// Most S3 compatible stores ignore the region, but the
+		// request signer needs one.
+		region = "us-east-1"
+	}
+	config := &aws.Config{
+		Region: aws.String(region),
+	}
+	if options.Endpoint != "" {
+		u, err := url.Parse(options.Endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", options.Endpoint)
 		}
-		log.Debugf("\tFile uploaded to, %s\n", result.Location)
-	} else {
-		result, err := uploader.Upload(&s3manager.UploadInput{
-			Bucket: aws.String(options.Bucket),
-			Key:    aws.String(filepath.Clean(folder + "/" + strings.Replace(f.Name(), options.Directory, "", -1))),
-			Body:   f,
-		})
+		config.Endpoint = aws.String(options.Endpoint)
+		config.DisableSSL = aws.Bool(u.Scheme == "http")
+	}
+	if options.PathStyle {
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	client, err := HTTPClient(options)
+	if err != nil {
+		return nil, err
+	}
+	config.HTTPClient = client
+	return config, nil
+}
+
+// HTTPClient caps the connections to the store and keeps them open
+// for reuse between parts and files.  It trusts the CA bundle in the
+// options, or skips verifying certificates altogether if asked to.
+// With a bandwidth limit, every connection shares it.
+func HTTPClient(options options.Options) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if options.CABundle != "" {
+		pem, err := ioutil.ReadFile(options.CABundle)
 		if err != nil {
-			return fmt.Errorf("failed to upload file, %v", err)
+			return nil, fmt.Errorf("unable to read CA bundle %s, %v", options.CABundle, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
 		}
-		log.Debugf("\tFile uploaded to, %s\n", result.Location)
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", options.CABundle)
+		}
+		tlsConfig.RootCAs = pool
 	}
-	return nil
+	if options.Insecure {
+		log.Warn("Not verifying the TLS certificate of the S3 endpoint.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if options.MaxConnections > 0 {
+		transport.MaxConnsPerHost = options.MaxConnections
+		transport.MaxIdleConnsPerHost = options.MaxConnections
+	}
+	limiter, err := Limiter(options)
+	if err != nil {
+		return nil, err
+	}
+	if limiter != nil {
+		transport.DialContext = dialer(transport.DialContext, limiter)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// ServerSideEncryption is the SSE mode for uploads.  An SSE-C key means
+// SSE-C and a KMS key means aws:kms unless another mode is asked for,
+// and "none" turns it off for stores that do not support it.
+func ServerSideEncryption(options options.Options) string {
+	switch strings.ToLower(options.SSE) {
+	case "none":
+		return ""
+	case "aes256":
+		return "AES256"
+	case "aws:kms", "kms":
+		return "aws:kms"
+	case "sse-c", "customer":
+		return SSECustomer
+	}
+	if hasCustomerKey(options) {
+		return SSECustomer
+	}
+	if options.AwsKey != "" {
+		return "aws:kms"
+	}
+	return ""
+}
+
+// CheckServerSideEncryption rejects an --sse mode that is not one of
+// those ServerSideEncryption knows, rather than quietly uploading
+// without it.
+func CheckServerSideEncryption(options options.Options) error {
+	switch strings.ToLower(options.SSE) {
+	case "", "none", "aes256", "aws:kms", "kms", "sse-c", "customer":
+		return nil
+	}
+	return fmt.Errorf("unknown --sse %s, use aws:kms, AES256, sse-c or none", options.SSE)
+}
+
+// Put uploads the body, using multipart uploads for large bodies.
+func (s *Store) Put(key string, body io.Reader, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	return s.put(key, body, opts, "")
+}
+
+// put uploads the body with its checksum, if known, for S3 to check.
+func (s *Store) put(key string, body io.Reader, opts storage.PutOptions, checksum string) (storage.ObjectInfo, error) {
+	log.Debugf("\tUploading %s.", key)
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+		Body:   s.limiter.Reader(body),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(Tagging(opts.Tags))
+	}
+	input.Metadata = aws.StringMap(opts.Metadata)
+	input.StorageClass = s.storageClass()
+	if checksum != "" && !s.options.NoChecksums {
+		// S3 refuses the upload if the body does not match.
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+	result, err := s.uploader.Upload(input)
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("failed to upload %s, %v", key, err)
+	}
+	log.Debugf("\tFile uploaded to, %s\n", result.Location)
+	return storage.ObjectInfo{
+		Key:      key,
+		ETag:     strings.Trim(aws.StringValue(result.ETag), `"`),
+		Checksum: checksum,
+	}, nil
 }
 
-// DownloadFile function to download a file from S3.
-func DownloadFile(directory string, pullfile string, options options.Options) (string, error) {
-	log.Debugf("\tDownloading file (1): %s", pullfile)
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(options.Region),
-	}))
+// checksumMode asks S3 for the checksum of an object, unless checksums
+// are turned off.
+func (s *Store) checksumMode() *string {
+	if s.options.NoChecksums {
+		return nil
+	}
+	return aws.String(s3.ChecksumModeEnabled)
+}
 
-	downloader := s3manager.NewDownloader(sess)
+// Get streams an object from the bucket.
+func (s *Store) Get(key string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	result, err := s.svc.GetObject(input)
+	if err != nil {
+		return nil, notFound(key, err)
+	}
+	return result.Body, nil
+}
 
-	filename := filepath.Clean(directory + "/" + pullfile)
-	dirname := filepath.Dir(filename)
-	log.Debugf("\tDownloading file (2): %s", filename)
+// GetFile downloads an object to a file with parallel ranged gets.  It
+// stops if the transfer window closes before it is done.
+func (s *Store) GetFile(key string, filename string) error {
+	log.Debugf("\tDownloading %s to %s", key, filename)
 
-	os.MkdirAll(dirname, os.ModePerm)
 	file, err := os.Create(filename)
 	if err != nil {
-		log.Debugf("\tDownloading file (3): %s", filename)
-		return "", fmt.Errorf("Unable to open file %q, %v", filename, err)
+		return fmt.Errorf("unable to open file %q, %v", filename, err)
 	}
 	defer file.Close()
 
-	log.Debugf("\tDownloading file (4): About to pull %s, from bucket %s", pullfile, options.Bucket)
-	// TODO:  Add the S3 KMS keys if needed.
-	_, err = downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(options.Bucket),
-			Key:    aws.String(pullfile),
-		})
+	// KMS objects are decrypted by S3 without being asked, SSE-C
+	// objects need the key on every ranged get.
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	_, err = s.downloader.Download(s.limiter.WriterAt(file), input)
 	if err != nil {
-		log.Debugf("\tDownloading file (5): %s", pullfile)
-		log.Errorf("Unable to download item %q, %v", pullfile, err)
+		return notFound(key, err)
+	}
+	return nil
+}
+
+// MaxPresign is the longest a presigned link can last.
+const MaxPresign = 7 * 24 * time.Hour
+
+// Presign makes a link to download an object that expires after a while.
+func (s *Store) Presign(key string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > MaxPresign {
+		return "", fmt.Errorf("presigned links must expire within %s, not %s", MaxPresign, expires)
+	}
+	if s.sseKey != nil {
+		return "", errSSECustomerLinks
+	}
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(expires)
+}
+
+// PresignPut makes a link to upload an object that expires after a
+// while, and the headers the upload must send with it.  The object is
+// encrypted at the bucket the way this store would encrypt it.
+func (s *Store) PresignPut(key string, expires time.Duration) (string, map[string]string, error) {
+	if expires <= 0 || expires > MaxPresign {
+		return "", nil, fmt.Errorf("presigned links must expire within %s, not %s", MaxPresign, expires)
+	}
+	if s.sseKey != nil {
+		return "", nil, errSSECustomerLinks
+	}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s.encryption()
+	if !s.options.NoChecksums {
+		// The uploader sends the checksum of the body with it, and S3
+		// refuses the upload if it does not match.
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	req, _ := s.svc.PutObjectRequest(input)
+	link, header, err := req.PresignRequest(expires)
+	if err != nil {
+		return "", nil, err
+	}
+	headers := map[string]string{}
+	for name, values := range header {
+		// Host is set by the client from the URL.
+		if !strings.EqualFold(name, "Host") {
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+	return link, headers, nil
+}
+
+// errSSECustomerLinks is returned for links to SSE-C objects.  Whoever
+// used the link would need the key too, which defeats the purpose.
+var errSSECustomerLinks = fmt.Errorf("presigned links can not be used with %s, the key would have to go with every request", SSECustomer)
+
+// List describes the objects under a prefix.
+func (s *Store) List(prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.options.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, storage.ObjectInfo{
+				Key:          aws.StringValue(o.Key),
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+				ETag:         strings.Trim(aws.StringValue(o.ETag), `"`),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s, %v", prefix, err)
+	}
+	return objects, nil
+}
+
+// Stat describes a single object.  The checksum of an object uploaded
+// in parts is left out, as the size of its parts is not known.
+func (s *Store) Stat(key string) (storage.ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket:       aws.String(s.options.Bucket),
+		Key:          aws.String(key),
+		ChecksumMode: s.checksumMode(),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = s.customerKey()
+	result, err := s.svc.HeadObject(input)
+	if err != nil {
+		return storage.ObjectInfo{}, notFound(key, err)
+	}
+	info := storage.ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(result.ContentLength),
+		LastModified: aws.TimeValue(result.LastModified),
+		ETag:         strings.Trim(aws.StringValue(result.ETag), `"`),
+	}
+	if checksum := aws.StringValue(result.ChecksumSHA256); !storage.IsComposite(checksum) {
+		info.Checksum = checksum
+	}
+	return info, nil
+}
+
+// Describe reads the tags, metadata, storage class and checksum of an
+// object.  Metadata names are lower case, whatever case S3 returns them in.
+func (s *Store) Describe(key string) (storage.Details, error) {
+	head := &s3.HeadObjectInput{
+		Bucket:       aws.String(s.options.Bucket),
+		Key:          aws.String(key),
+		ChecksumMode: s.checksumMode(),
+	}
+	head.SSECustomerAlgorithm, head.SSECustomerKey = s.customerKey()
+	result, err := s.svc.HeadObject(head)
+	if err != nil {
+		return storage.Details{}, notFound(key, err)
+	}
+	details := storage.Details{
+		Tags:         map[string]string{},
+		Metadata:     map[string]string{},
+		StorageClass: aws.StringValue(result.StorageClass),
+		Checksum:     aws.StringValue(result.ChecksumSHA256),
+	}
+	if details.StorageClass == "" {
+		// S3 leaves the header out for the default class.
+		details.StorageClass = "STANDARD"
+	}
+	for name, value := range result.Metadata {
+		details.Metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+	tagging, err := s.svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return details, fmt.Errorf("unable to get the tags of %s, %v", key, err)
+	}
+	for _, tag := range tagging.TagSet {
+		details.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return details, nil
+}
+
+// Delete removes an object.  In a versioned bucket this leaves a
+// delete marker.
+func (s *Store) Delete(key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.options.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s, %v", key, err)
+	}
+	return nil
+}
+
+// encryption is the SSE mode and KMS key to send with an upload.
+func (s *Store) encryption() (*string, *string) {
+	switch ServerSideEncryption(s.options) {
+	case "aws:kms":
+		if s.options.AwsKey != "" {
+			return aws.String("aws:kms"), aws.String(s.options.AwsKey)
+		}
+		return aws.String("aws:kms"), nil
+	case "AES256":
+		return aws.String("AES256"), nil
+	}
+	return nil, nil
+}
+
+// storageClass is the storage class for uploads, or nil for the
+// bucket's default.
+func (s *Store) storageClass() *string {
+	if s.options.StorageClass == "" {
+		return nil
+	}
+	return aws.String(strings.ToUpper(s.options.StorageClass))
+}
+
+// checkStorageClass refuses the archive classes, whose objects have to
+// be restored before they can be read, which would leave shares that
+// can not be listed or decrypted.
+func checkStorageClass(class string) error {
+	switch strings.ToUpper(class) {
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		return fmt.Errorf("objects in the %s storage class can not be read without restoring them, pick another class or use a lifecycle rule", strings.ToUpper(class))
+	}
+	return nil
+}
+
+// Tagging encodes object tags the way S3 expects them on upload.
+func Tagging(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// notFound maps the S3 missing object errors to storage.ErrNotFound,
+// and explains the errors for SSE-C objects read without the right key.
+func notFound(key string, err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch {
+		case aerr.Code() == s3.ErrCodeNoSuchKey, aerr.Code() == "NotFound":
+			return storage.ErrNotFound
+		case strings.Contains(aerr.Message(), "stored using a form of Server Side Encryption"):
+			return fmt.Errorf("unable to get %s, it is encrypted with %s, give the key with --sse-customer-key-file or $%s", key, SSECustomer, CustomerKeyEnv)
+		case strings.Contains(aerr.Message(), "MD5 hash of the key did not match"):
+			return fmt.Errorf("unable to get %s, it was encrypted with a different %s key", key, SSECustomer)
+		}
 	}
-	log.Debugf("\tDownloading file (6): %s", file.Name())
-	return file.Name(), nil
+	return fmt.Errorf("unable to get %s, %v", key, err)
 }
@@ -0,0 +1,85 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	options "github.com/jemurai/s3s2/options"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// CustomerKeyEnv is the environment variable that holds a base64 SSE-C
+// key, for keys kept in a secret manager or CI secret rather than a file.
+const CustomerKeyEnv = "S3S2_SSE_CUSTOMER_KEY"
+
+// SSECustomer is the mode recorded for objects encrypted at the bucket
+// with a key the customer provides on every request.
+const SSECustomer = "SSE-C"
+
+// customerKeySize is the size of an SSE-C key, which is always AES-256.
+const customerKeySize = 32
+
+// hasCustomerKey reports whether an SSE-C key was given.
+func hasCustomerKey(options options.Options) bool {
+	return options.SSECustomerKeyFile != "" || os.Getenv(CustomerKeyEnv) != ""
+}
+
+// CustomerKey reads the SSE-C key from the key file, which may hold the
+// 32 raw bytes or their base64, or from the environment.  It is nil when
+// no key was given.
+func CustomerKey(options options.Options) ([]byte, error) {
+	source, data := CustomerKeyEnv, []byte(os.Getenv(CustomerKeyEnv))
+	if options.SSECustomerKeyFile != "" {
+		var err error
+		source = options.SSECustomerKeyFile
+		if data, err = ioutil.ReadFile(options.SSECustomerKeyFile); err != nil {
+			return nil, fmt.Errorf("unable to read the SSE-C key, %v", err)
+		}
+	} else if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) == customerKeySize {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != customerKeySize {
+		return nil, fmt.Errorf("the SSE-C key in %s must be %d bytes, raw or base64", source, customerKeySize)
+	}
+	return key, nil
+}
+
+// CustomerKeyMD5 identifies an SSE-C key the way S3 does, by the base64
+// MD5 of the key.  It is safe to record, the key is not.
+func CustomerKeyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// customerKey is the algorithm and key to send with every request for
+// an object encrypted with SSE-C, or nils when the mode is not SSE-C.
+// The SDK encodes the key and adds its MD5.
+func (s *Store) customerKey() (*string, *string) {
+	if s.sseKey == nil {
+		return nil, nil
+	}
+	return aws.String("AES256"), aws.String(string(s.sseKey))
+}
@@ -0,0 +1,68 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	options "github.com/jemurai/s3s2/options"
+	throttle "github.com/jemurai/s3s2/throttle"
+)
+
+// Every transfer shares one limiter, so the bandwidth cap holds across
+// all the files and parts in flight at once.
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*throttle.Limiter{}
+)
+
+// Limiter returns the shared limiter for the bandwidth and transfer
+// windows in the options, nil if there are none.
+func Limiter(options options.Options) (*throttle.Limiter, error) {
+	rate, err := throttle.ParseRate(options.MaxBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := throttle.ParseSchedule(options.TransferWindows)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%d|%s", rate, strings.Join(options.TransferWindows, ","))
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if l, ok := limiters[key]; ok {
+		return l, nil
+	}
+	l := throttle.NewLimiter(rate, schedule)
+	limiters[key] = l
+	return l, nil
+}
+
+// dialer wraps the connections a transport dials so that they count
+// against the limiter.
+func dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error), l *throttle.Limiter) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return l.Conn(c), nil
+	}
+}
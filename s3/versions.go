@@ -0,0 +1,86 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ObjectVersion is a version of an object in a versioned bucket, or a
+// delete marker left where an object was deleted.
+type ObjectVersion struct {
+	Key          string
+	VersionID    string
+	DeleteMarker bool
+	Size         int64
+}
+
+// Versioned reports whether versioning is, or ever was, turned on for
+// the bucket.  Deleting an object in such a bucket only hides it behind
+// a delete marker.
+func (s *Store) Versioned() (bool, error) {
+	result, err := s.svc.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.options.Bucket),
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to read the versioning of %s, %v", s.options.Bucket, err)
+	}
+	return aws.StringValue(result.Status) != "", nil
+}
+
+// Versions lists every version and delete marker under a prefix.
+func (s *Store) Versions(prefix string) ([]ObjectVersion, error) {
+	var versions []ObjectVersion
+	err := s.svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.options.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range page.Versions {
+			versions = append(versions, ObjectVersion{
+				Key:       aws.StringValue(v.Key),
+				VersionID: aws.StringValue(v.VersionId),
+				Size:      aws.Int64Value(v.Size),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			versions = append(versions, ObjectVersion{
+				Key:          aws.StringValue(m.Key),
+				VersionID:    aws.StringValue(m.VersionId),
+				DeleteMarker: true,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the versions under %s, %v", prefix, err)
+	}
+	return versions, nil
+}
+
+// DeleteVersion removes one version of an object for good.
+func (s *Store) DeleteVersion(key string, versionID string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket:    aws.String(s.options.Bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s version %s, %v", key, versionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/encrypt"
+	"github.com/jemurai/s3s2/manifest"
+)
+
+var _ = Describe("Signed manifests", func() {
+	var (
+		files []manifest.FileDescription
+	)
+
+	BeforeEach(func() {
+		files = []manifest.FileDescription{
+			{Name: "/a.csv", Hash: "aaa"},
+			{Name: "/b.csv", Hash: "bbb"},
+			{Name: "/c.csv", Hash: "ccc"},
+		}
+	})
+
+	Describe("Merkle root", func() {
+		It("should not depend on file order", func() {
+			reversed := []manifest.FileDescription{files[2], files[1], files[0]}
+			Expect(manifest.MerkleRoot(reversed)).To(Equal(manifest.MerkleRoot(files)))
+		})
+
+		It("should change when a file changes", func() {
+			root := manifest.MerkleRoot(files)
+			files[1].Hash = "ddd"
+			Expect(manifest.MerkleRoot(files)).NotTo(Equal(root))
+		})
+
+		It("should prove inclusion of every file", func() {
+			root := manifest.MerkleRoot(files)
+			for _, f := range files {
+				proof, err := manifest.BuildProof(files, f.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.VerifyProof(root, proof)).To(BeTrue())
+			}
+		})
+
+		It("should reject a proof for altered content", func() {
+			root := manifest.MerkleRoot(files)
+			proof, _ := manifest.BuildProof(files, "/b.csv")
+			proof.Hash = "zzz"
+			Expect(manifest.VerifyProof(root, proof)).To(BeFalse())
+		})
+	})
+
+	Describe("Signed digest", func() {
+		It("should cover the whole manifest but the signature", func() {
+			m := manifest.Manifest{Folder: "acme_s3s2_1", Files: files, Root: manifest.MerkleRoot(files)}
+			digest := manifest.SignedDigest(m)
+			m.Signature, m.Signer = "signature", "signer"
+			Expect(manifest.SignedDigest(m)).To(Equal(digest))
+			m.Recipients = []string{"someone else"}
+			Expect(manifest.SignedDigest(m)).NotTo(Equal(digest))
+		})
+	})
+
+	Describe("Share digest", func() {
+		It("should not change as the share is uploaded or signed", func() {
+			m := manifest.Manifest{Folder: "acme_s3s2_1", Files: append([]manifest.FileDescription{}, files...), Pending: []string{files[0].Name}}
+			digest := manifest.ShareDigest(m)
+			m.Uploaded(files[0].Name, "acme_s3s2_1/"+files[0].Name+".gpg", "etag")
+			m.Checksummed(files[0].Name, "checksum", 0)
+			m.Signature, m.Signer = "signature", "signer"
+			Expect(manifest.ShareDigest(m)).To(Equal(digest))
+			m.Recipients = []string{"someone else"}
+			Expect(manifest.ShareDigest(m)).NotTo(Equal(digest))
+			m.Recipients = nil
+			m.Files[0].Hash = "another hash"
+			Expect(manifest.ShareDigest(m)).NotTo(Equal(digest))
+		})
+	})
+
+	Describe("Signatures", func() {
+		var (
+			dir string
+		)
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "s3s2-keys")
+			encrypt.GenerateKeys(dir, "sender", 1024)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should verify a signed root", func() {
+			pub := filepath.Join(dir, "sender.pubkey")
+			priv := filepath.Join(dir, "sender.privkey")
+			root := []byte(manifest.MerkleRoot(files))
+			signature, err := encrypt.Sign(root, pub, priv)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encrypt.Verify(root, signature, pub)).To(Succeed())
+			Expect(encrypt.Verify([]byte("tampered"), signature, pub)).NotTo(Succeed())
+		})
+	})
+})
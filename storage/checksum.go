@@ -0,0 +1,78 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Checksum is the SHA-256 of a local file the way S3 reports it for an
+// object uploaded in parts of partSize: base64 for an object sent whole,
+// or for one sent in parts, the checksum of the part checksums followed
+// by - and the number of parts.  A partSize of 0 is a whole object.
+func Checksum(filename string, partSize int64) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if partSize <= 0 || stat.Size() <= partSize {
+		return ReaderChecksum(f)
+	}
+	var parts [][]byte
+	for offset := int64(0); offset < stat.Size(); offset += partSize {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, partSize)); err != nil {
+			return "", err
+		}
+		parts = append(parts, h.Sum(nil))
+	}
+	return CompositeChecksum(parts), nil
+}
+
+// ReaderChecksum is the SHA-256 of everything read from r, the way S3
+// reports the checksum of an object uploaded whole.
+func ReaderChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// CompositeChecksum is the checksum S3 gives an object uploaded in parts
+// with these SHA-256 sums, in part order.
+func CompositeChecksum(parts [][]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+// IsComposite reports whether a checksum is of the parts of an object
+// rather than of the object itself.
+func IsComposite(checksum string) bool {
+	return strings.Contains(checksum, "-")
+}
@@ -0,0 +1,108 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HTTP reads objects through links, such as presigned S3 URLs, so a
+// share can be downloaded by someone without credentials for the
+// bucket.  Only the objects it has a link for can be read.
+type HTTP struct {
+	client *http.Client
+	links  map[string]string
+}
+
+// NewHTTP reads objects from the links, keyed by object key, with the
+// client or the default one.
+func NewHTTP(client *http.Client, links map[string]string) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTP{client: client, links: links}
+}
+
+// Get downloads the object from its link.
+func (h *HTTP) Get(key string) (io.ReadCloser, error) {
+	link, ok := h.links[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %v, there is no link for it", key, ErrNotFound)
+	}
+	resp, err := h.client.Get(link)
+	if err != nil {
+		// The error includes the link, which is a credential.
+		return nil, fmt.Errorf("unable to download %s, %v", key, redact(err, link))
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %v", key, ErrNotFound)
+	case http.StatusForbidden:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to download %s, the link has expired or is not valid", key)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to download %s, %s", key, resp.Status)
+	}
+}
+
+// List describes the objects there are links for.  Their sizes are not
+// known.
+func (h *HTTP) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for key := range h.links {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// Stat only knows whether there is a link for the object, since a
+// presigned GET link can not be used for a HEAD request.
+func (h *HTTP) Stat(key string) (ObjectInfo, error) {
+	if _, ok := h.links[key]; !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return ObjectInfo{Key: key}, nil
+}
+
+// Put is not possible through read links.
+func (h *HTTP) Put(key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	return ObjectInfo{}, ErrReadOnly
+}
+
+// Delete is not possible through read links.
+func (h *HTTP) Delete(key string) error {
+	return ErrReadOnly
+}
+
+// redact takes the query, which holds the signature, out of an error
+// about a link.
+func redact(err error, link string) string {
+	msg := err.Error()
+	if i := strings.Index(link, "?"); i >= 0 {
+		msg = strings.Replace(msg, link, link[:i]+"?...", -1)
+	}
+	return msg
+}
@@ -0,0 +1,217 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local keeps objects as files under a root directory.  It is meant for
+// air gapped transfers on removable media and for tests that should not
+// need S3.  The tags and metadata of each object are kept in a JSON
+// file under detailsDir.
+type Local struct {
+	root string
+}
+
+// detailsDir holds the tags and metadata of the objects.  It is not
+// listed and no object can be written into it.
+const detailsDir = ".s3s2-details"
+
+// NewLocal stores objects under the root directory.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	if clean == string(filepath.Separator) || strings.HasPrefix(clean+string(filepath.Separator), filepath.Join(string(filepath.Separator), detailsDir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(l.root, clean), nil
+}
+
+func (l *Local) detailsPath(key string) (string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(l.root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, detailsDir, rel+".json"), nil
+}
+
+// Put writes the object to a temporary file and renames it into place,
+// so a reader never sees a partial object.
+func (l *Local) Put(key string, body io.Reader, opts PutOptions) (ObjectInfo, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return ObjectInfo{}, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".s3s2-put-")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := md5.New()
+	checksum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher, checksum), body); err != nil {
+		tmp.Close()
+		return ObjectInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return ObjectInfo{}, err
+	}
+	details := Details{
+		Tags:     opts.Tags,
+		Metadata: opts.Metadata,
+		Checksum: base64.StdEncoding.EncodeToString(checksum.Sum(nil)),
+	}
+	if err := l.writeDetails(key, details); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := l.Stat(key)
+	info.ETag = hex.EncodeToString(hasher.Sum(nil))
+	info.Checksum = details.Checksum
+	return info, err
+}
+
+// writeDetails replaces the tags and metadata kept for an object.
+func (l *Local) writeDetails(key string, details Details) error {
+	path, err := l.detailsPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(details)
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Describe reads the tags and metadata kept for an object.  Objects
+// written before they were kept have none.
+func (l *Local) Describe(key string) (Details, error) {
+	if _, err := l.Stat(key); err != nil {
+		return Details{}, err
+	}
+	path, err := l.detailsPath(key)
+	if err != nil {
+		return Details{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Details{}, nil
+	} else if err != nil {
+		return Details{}, err
+	}
+	var details Details
+	err = json.Unmarshal(data, &details)
+	return details, err
+}
+
+// Get opens the file for the object.
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// List walks the root for files under the prefix.
+func (l *Local) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.Walk(l.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path == filepath.Join(l.root, detailsDir) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".s3s2-put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+// Stat describes the file for the object.
+func (l *Local) Stat(key string) (ObjectInfo, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotFound
+	} else if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// Delete removes the file for the object.
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if details, err := l.detailsPath(key); err == nil {
+		os.Remove(details)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	// Checksum is the SHA-256 of the object as uploaded, see Checksum,
+	// and PartSize the size of its parts if it was sent in parts.
+	Checksum string
+	PartSize int64
+}
+
+// PutOptions are the per object settings for a Put.
+type PutOptions struct {
+	Tags map[string]string
+	// Metadata is kept with the object and returned with it, unlike
+	// tags it can not be changed without writing the object again.
+	Metadata map[string]string
+}
+
+// Details are the tags and metadata kept with an object.
+type Details struct {
+	Tags         map[string]string
+	Metadata     map[string]string
+	StorageClass string
+	// Checksum is the SHA-256 the store has for the object, if any.
+	Checksum string
+}
+
+// Storage is where shares are written to and read from.  The commands
+// only depend on this, so shares can go to S3 or to a local directory.
+type Storage interface {
+	// Put writes the body to the key, replacing any existing object.
+	Put(key string, body io.Reader, opts PutOptions) (ObjectInfo, error)
+	// Get opens the object for reading.  The caller closes it.
+	Get(key string) (io.ReadCloser, error)
+	// List describes every object whose key starts with the prefix.
+	List(prefix string) ([]ObjectInfo, error)
+	// Stat describes a single object.
+	Stat(key string) (ObjectInfo, error)
+	// Delete removes an object.
+	Delete(key string) error
+}
+
+// FileGetter is implemented by storage that can download straight to
+// a file faster than streaming it, such as parallel ranged S3 gets.
+type FileGetter interface {
+	GetFile(key string, filename string) error
+}
+
+// FilePutter is implemented by storage that can upload a local file
+// better than streaming it, such as resumable multipart uploads to S3.
+type FilePutter interface {
+	PutFile(key string, filename string, opts PutOptions) (ObjectInfo, error)
+}
+
+// Resumer is implemented by storage that keeps the state of interrupted
+// uploads.  Resumable reports whether an upload of the local file can be
+// picked up where it left off, in which case the file must not be
+// rebuilt.
+type Resumer interface {
+	Resumable(filename string) bool
+}
+
+// Presigner is implemented by storage that can hand out time limited
+// links to read an object without credentials.
+type Presigner interface {
+	Presign(key string, expires time.Duration) (string, error)
+}
+
+// Describer is implemented by storage that keeps tags and metadata
+// with objects, such as S3.
+type Describer interface {
+	Describe(key string) (Details, error)
+}
+
+// ErrNoDetails is returned for storage that keeps no tags or metadata.
+var ErrNoDetails = errors.New("storage keeps no tags or metadata")
+
+// ErrReadOnly is returned when writing to storage that can only be read.
+var ErrReadOnly = errors.New("storage is read only")
+
+// PutFile uploads a local file.  The checksum of the file is returned
+// with the object when the storage does not report one itself.
+func PutFile(s Storage, key string, filename string, opts PutOptions) (ObjectInfo, error) {
+	if fp, ok := s.(FilePutter); ok {
+		return fp.PutFile(key, filename, opts)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer f.Close()
+	info, err := s.Put(key, f, opts)
+	if err != nil || info.Checksum != "" {
+		return info, err
+	}
+	info.Checksum, err = Checksum(filename, 0)
+	return info, err
+}
+
+// Resumable reports whether the storage can resume an upload of a file.
+func Resumable(s Storage, filename string) bool {
+	r, ok := s.(Resumer)
+	return ok && r.Resumable(filename)
+}
+
+// Presign returns a link to read an object that expires after a while.
+func Presign(s Storage, key string, expires time.Duration) (string, error) {
+	p, ok := s.(Presigner)
+	if !ok {
+		return "", errors.New("presigned links need an S3 bucket")
+	}
+	return p.Presign(key, expires)
+}
+
+// Describe reads the tags and metadata of an object.
+func Describe(s Storage, key string) (Details, error) {
+	d, ok := s.(Describer)
+	if !ok {
+		return Details{}, ErrNoDetails
+	}
+	return d.Describe(key)
+}
+
+// GetFile downloads an object to a local file, creating directories
+// as needed.
+func GetFile(s Storage, key string, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	if fg, ok := s.(FileGetter); ok {
+		return fg.GetFile(key, filename)
+	}
+
+	body, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, body)
+	return err
+}
+
+// ReadObject reads a small object, such as a manifest, into memory.
+func ReadObject(s Storage, key string) ([]byte, error) {
+	body, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// WriteObject writes a small object from memory.
+func WriteObject(s Storage, key string, data []byte, opts PutOptions) (ObjectInfo, error) {
+	return s.Put(key, bytes.NewReader(data), opts)
+}
@@ -0,0 +1,149 @@
+package main_test
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/archive"
+	"github.com/jemurai/s3s2/encrypt"
+	"github.com/jemurai/s3s2/options"
+	"github.com/jemurai/s3s2/storage"
+)
+
+var _ = Describe("Local storage", func() {
+	var (
+		dir   string
+		store *storage.Local
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-storage")
+		store = storage.NewLocal(filepath.Join(dir, "bucket"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should put, get, list and delete objects", func() {
+		info, err := store.Put("share/a.txt", strings.NewReader("hello"), storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size).To(Equal(int64(5)))
+		Expect(info.ETag).To(Equal("5d41402abc4b2a76b9719d911017c592"))
+
+		data, err := storage.ReadObject(store, "share/a.txt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("hello"))
+
+		storage.WriteObject(store, "other/b.txt", []byte("b"), storage.PutOptions{})
+		objects, err := store.List("share/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(1))
+		Expect(objects[0].Key).To(Equal("share/a.txt"))
+
+		Expect(store.Delete("share/a.txt")).To(Succeed())
+		_, err = store.Stat("share/a.txt")
+		Expect(err).To(Equal(storage.ErrNotFound))
+		_, err = store.Get("share/a.txt")
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+
+	It("should keep tags and metadata", func() {
+		storage.WriteObject(store, "share/a.txt", []byte("a"), storage.PutOptions{
+			Tags:     map[string]string{"share": "share"},
+			Metadata: map[string]string{"s3s2-version": "dev"},
+		})
+		details, err := storage.Describe(store, "share/a.txt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(details.Tags).To(Equal(map[string]string{"share": "share"}))
+		Expect(details.Metadata).To(Equal(map[string]string{"s3s2-version": "dev"}))
+		Expect(details.Checksum).NotTo(BeEmpty())
+
+		objects, _ := store.List("")
+		Expect(objects).To(HaveLen(1))
+		store.Delete("share/a.txt")
+		_, err = storage.Describe(store, "share/a.txt")
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+
+	It("should keep keys inside the root", func() {
+		storage.WriteObject(store, "../../escape.txt", []byte("x"), storage.PutOptions{})
+		_, err := os.Stat(filepath.Join(dir, "escape.txt"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		_, err = store.Stat("escape.txt")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should checksum files the way S3 does", func() {
+		file := filepath.Join(dir, "object")
+		ioutil.WriteFile(file, []byte("0123456789"), 0600)
+
+		whole, err := storage.Checksum(file, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(whole).To(Equal("hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII="))
+		Expect(storage.Checksum(file, 10)).To(Equal(whole))
+		Expect(storage.IsComposite(whole)).To(BeFalse())
+
+		var sums [][]byte
+		for _, part := range []string{"0123", "4567", "89"} {
+			sum := sha256.Sum256([]byte(part))
+			sums = append(sums, sum[:])
+		}
+		parts, err := storage.Checksum(file, 4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parts).To(Equal(storage.CompositeChecksum(sums)))
+		Expect(parts).To(HaveSuffix("-3"))
+		Expect(storage.IsComposite(parts)).To(BeTrue())
+	})
+
+	It("should return the checksum of a file put", func() {
+		file := filepath.Join(dir, "object")
+		ioutil.WriteFile(file, []byte("0123456789"), 0600)
+		info, err := storage.PutFile(store, "share/object", file, storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Checksum).To(Equal("hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII="))
+	})
+
+	It("should report files that cannot be zipped or encrypted", func() {
+		src := filepath.Join(dir, "src") + "/"
+		os.MkdirAll(src, os.ModePerm)
+		_, err := archive.ZipFile(src+"vanished.csv", options.Options{Directory: src})
+		Expect(err).To(HaveOccurred())
+
+		ioutil.WriteFile(src+"data.csv", []byte("id,name\n1,alice\n"), 0600)
+		Expect(encrypt.Encrypt(src+"data.csv", filepath.Join(dir, "missing.pubkey"))).NotTo(Succeed())
+	})
+
+	It("should round trip an encrypted file", func() {
+		encrypt.GenerateKeys(dir, "receiver", 1024)
+		pub := filepath.Join(dir, "receiver.pubkey")
+		priv := filepath.Join(dir, "receiver.privkey")
+
+		src := filepath.Join(dir, "src") + "/"
+		os.MkdirAll(src, os.ModePerm)
+		ioutil.WriteFile(src+"data.csv", []byte("id,name\n1,alice\n"), 0600)
+
+		zipped, err := archive.ZipFile(src+"data.csv", options.Options{Directory: src})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypt.Encrypt(zipped, pub)).To(Succeed())
+		_, err = storage.PutFile(store, "share/data.csv.zip.gpg", zipped+".gpg", storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		dest := filepath.Join(dir, "dest") + "/"
+		fn := dest + "share/data.csv.zip.gpg"
+		Expect(storage.GetFile(store, "share/data.csv.zip.gpg", fn)).To(Succeed())
+		Expect(encrypt.Decrypt(fn, pub, priv)).To(Succeed())
+		out, err := archive.UnZipFile(strings.TrimSuffix(fn, ".gpg"), dest)
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(out)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("id,name\n1,alice\n"))
+	})
+})
@@ -0,0 +1,366 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package throttle caps the bandwidth of transfers and keeps them to
+// the times of day they are allowed in.
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrClosed stops a transfer that is still running when its transfer
+// window closes.
+var ErrClosed = errors.New("the transfer window closed")
+
+// Window is a time of day transfers may run in, from Start up to End
+// after midnight.  A window that ends before it starts runs past
+// midnight, such as 19:00-06:00.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow reads a window such as 19:00-06:00.
+func ParseWindow(spec string) (Window, error) {
+	spec = strings.Replace(spec, "\u2013", "-", 1)
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("invalid transfer window %q, use HH:MM-HH:MM", spec)
+	}
+	var w Window
+	var err error
+	if w.Start, err = parseClock(parts[0]); err != nil {
+		return Window{}, fmt.Errorf("invalid transfer window %q, %v", spec, err)
+	}
+	if w.End, err = parseClock(parts[1]); err != nil {
+		return Window{}, fmt.Errorf("invalid transfer window %q, %v", spec, err)
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("transfer window %q is empty", spec)
+	}
+	return w, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the window is open at a time.
+func (w Window) Contains(t time.Time) bool {
+	d := sinceMidnight(t)
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// opens is the first time at or after t that the window opens.
+func (w Window) opens(t time.Time) time.Time {
+	open := midnight(t).Add(w.Start)
+	if open.Before(t) {
+		open = midnight(t.AddDate(0, 0, 1)).Add(w.Start)
+	}
+	return open
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return t.Sub(midnight(t))
+}
+
+// Schedule is the windows transfers may run in.  An empty schedule is
+// always open.
+type Schedule []Window
+
+// ParseSchedule reads a window from each spec.
+func ParseSchedule(specs []string) (Schedule, error) {
+	var s Schedule
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+// Next is t if the schedule is open then, or else when it next opens.
+func (s Schedule) Next(t time.Time) time.Time {
+	if len(s) == 0 {
+		return t
+	}
+	var next time.Time
+	for _, w := range s {
+		if w.Contains(t) {
+			return t
+		}
+		if open := w.opens(t); next.IsZero() || open.Before(next) {
+			next = open
+		}
+	}
+	return next
+}
+
+// ParseRate reads a bandwidth such as 10MB, 512KiB/s or 20Mbit.  Byte
+// units count in 1024s and bit units in 1000s.  An empty rate is 0, no
+// limit.
+func ParseRate(value string) (int64, error) {
+	spec := strings.ToLower(strings.TrimSpace(value))
+	spec = strings.TrimSuffix(spec, "/s")
+	if spec == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(spec, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	number, unit := spec, ""
+	if i >= 0 {
+		number, unit = spec[:i], strings.TrimSpace(spec[i:])
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, use a rate such as 10MB or 20Mbit", value)
+	}
+	var scale float64
+	switch unit {
+	case "", "b":
+		scale = 1
+	case "k", "kb", "kib":
+		scale = 1 << 10
+	case "m", "mb", "mib":
+		scale = 1 << 20
+	case "g", "gb", "gib":
+		scale = 1 << 30
+	case "kbit", "kbps":
+		scale = 1e3 / 8
+	case "mbit", "mbps":
+		scale = 1e6 / 8
+	case "gbit", "gbps":
+		scale = 1e9 / 8
+	default:
+		return 0, fmt.Errorf("invalid bandwidth %q, unknown unit %q", value, unit)
+	}
+	return int64(n * scale), nil
+}
+
+// Limiter is a token bucket shared by every transfer, so the rate holds
+// across all of them together, and the schedule they pause outside of.
+// A nil Limiter does not limit anything.
+type Limiter struct {
+	rate     float64
+	burst    float64
+	schedule Schedule
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	paused time.Time
+}
+
+// NewLimiter limits transfers to rate bytes a second, or not at all for
+// 0, and to the schedule.  It returns nil if there is nothing to limit.
+func NewLimiter(rate int64, schedule Schedule) *Limiter {
+	if rate <= 0 && len(schedule) == 0 {
+		return nil
+	}
+	// A quarter of a second of tokens keeps the rate smooth without
+	// cutting writes too small.
+	burst := float64(rate) / 4
+	if burst < 4096 {
+		burst = 4096
+	}
+	return &Limiter{rate: float64(rate), burst: burst, schedule: schedule, last: time.Now()}
+}
+
+// WaitN blocks until n more bytes may be transferred.  Callers that are
+// over the rate wait their turn in the order they asked.
+func (l *Limiter) WaitN(n int) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Open reports whether the schedule is open now.
+func (l *Limiter) Open() bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	return !l.schedule.Next(now).After(now)
+}
+
+// Pause blocks while the schedule is closed.  Transfers call it before
+// each file or part, and Reader and WriterAt stop the ones in flight
+// when the schedule closes.
+func (l *Limiter) Pause() {
+	if l == nil {
+		return
+	}
+	for {
+		now := time.Now()
+		next := l.schedule.Next(now)
+		if !next.After(now) {
+			return
+		}
+		l.mu.Lock()
+		if !l.paused.Equal(next) {
+			log.Infof("Outside the transfer windows, pausing until %s.", next.Format("Mon 15:04"))
+			l.paused = next
+		}
+		l.mu.Unlock()
+		time.Sleep(next.Sub(now))
+	}
+}
+
+// Reader fails with ErrClosed once the schedule closes, so the transfer
+// reading from r stops with its window.  It keeps the io.Seeker and
+// io.ReaderAt of r, which uploads send parts and retries with.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	if l == nil || len(l.schedule) == 0 {
+		return r
+	}
+	if rs, ok := r.(readSeekerAt); ok {
+		return &windowReadSeeker{readSeekerAt: rs, limiter: l}
+	}
+	return &windowReader{Reader: r, limiter: l}
+}
+
+// WriterAt fails with ErrClosed once the schedule closes, so a download
+// writing to w in parts stops with its window.
+func (l *Limiter) WriterAt(w io.WriterAt) io.WriterAt {
+	if l == nil || len(l.schedule) == 0 {
+		return w
+	}
+	return &windowWriterAt{WriterAt: w, limiter: l}
+}
+
+type readSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+type windowReader struct {
+	io.Reader
+	limiter *Limiter
+}
+
+func (r *windowReader) Read(p []byte) (int, error) {
+	if !r.limiter.Open() {
+		return 0, ErrClosed
+	}
+	return r.Reader.Read(p)
+}
+
+type windowReadSeeker struct {
+	readSeekerAt
+	limiter *Limiter
+}
+
+func (r *windowReadSeeker) Read(p []byte) (int, error) {
+	if !r.limiter.Open() {
+		return 0, ErrClosed
+	}
+	return r.readSeekerAt.Read(p)
+}
+
+func (r *windowReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	if !r.limiter.Open() {
+		return 0, ErrClosed
+	}
+	return r.readSeekerAt.ReadAt(p, off)
+}
+
+type windowWriterAt struct {
+	io.WriterAt
+	limiter *Limiter
+}
+
+func (w *windowWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if !w.limiter.Open() {
+		return 0, ErrClosed
+	}
+	return w.WriterAt.WriteAt(p, off)
+}
+
+// Conn limits the bytes read and written on a connection.
+func (l *Limiter) Conn(c net.Conn) net.Conn {
+	if l == nil || l.rate <= 0 {
+		return c
+	}
+	return &conn{Conn: c, limiter: l}
+}
+
+type conn struct {
+	net.Conn
+	limiter *Limiter
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(p) > int(c.limiter.burst) {
+		p = p[:int(c.limiter.burst)]
+	}
+	n, err := c.Conn.Read(p)
+	c.limiter.WaitN(n)
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if chunk > int(c.limiter.burst) {
+			chunk = int(c.limiter.burst)
+		}
+		c.limiter.WaitN(chunk)
+		n, err := c.Conn.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
@@ -0,0 +1,132 @@
+package main_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/throttle"
+)
+
+var _ = Describe("Throttling transfers", func() {
+	It("should read bandwidths in bytes and bits", func() {
+		for spec, rate := range map[string]int64{
+			"":         0,
+			"2048":     2048,
+			"10MB":     10 << 20,
+			"512KiB/s": 512 << 10,
+			"1.5m":     3 << 19,
+			"20Mbit":   2500000,
+			"8 kbps":   1000,
+		} {
+			Expect(throttle.ParseRate(spec)).To(Equal(rate), spec)
+		}
+		_, err := throttle.ParseRate("10 furlongs")
+		Expect(err).To(HaveOccurred())
+		_, err = throttle.ParseRate("fast")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read transfer windows", func() {
+		w, err := throttle.ParseWindow("19:00-06:30")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Start).To(Equal(19 * time.Hour))
+		Expect(w.End).To(Equal(6*time.Hour + 30*time.Minute))
+
+		_, err = throttle.ParseWindow("19:00–06:00")
+		Expect(err).NotTo(HaveOccurred())
+		for _, spec := range []string{"19:00", "7pm-6am", "25:00-06:00", "06:00-06:00"} {
+			_, err := throttle.ParseWindow(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+
+	It("should only open windows in their times of day", func() {
+		day := func(hour, minute int) time.Time {
+			return time.Date(2019, 6, 30, hour, minute, 0, 0, time.UTC)
+		}
+		night, _ := throttle.ParseWindow("19:00-06:00")
+		Expect(night.Contains(day(23, 0))).To(BeTrue())
+		Expect(night.Contains(day(2, 0))).To(BeTrue())
+		Expect(night.Contains(day(6, 0))).To(BeFalse())
+		Expect(night.Contains(day(12, 0))).To(BeFalse())
+
+		lunch, _ := throttle.ParseWindow("12:00-13:00")
+		Expect(lunch.Contains(day(12, 30))).To(BeTrue())
+		Expect(lunch.Contains(day(13, 0))).To(BeFalse())
+
+		schedule, err := throttle.ParseSchedule([]string{"19:00-06:00", "12:00-13:00"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schedule.Next(day(2, 0))).To(Equal(day(2, 0)))
+		Expect(schedule.Next(day(9, 0))).To(Equal(day(12, 0)))
+		Expect(schedule.Next(day(14, 0))).To(Equal(day(19, 0)))
+		Expect(throttle.Schedule{}.Next(day(9, 0))).To(Equal(day(9, 0)))
+
+		morning, _ := throttle.ParseSchedule([]string{"08:00-09:00"})
+		Expect(morning.Next(day(10, 0))).To(Equal(time.Date(2019, 7, 1, 8, 0, 0, 0, time.UTC)))
+	})
+
+	It("should not limit anything without a rate or windows", func() {
+		l := throttle.NewLimiter(0, nil)
+		Expect(l).To(BeNil())
+		l.Pause()
+		l.WaitN(1 << 30)
+		client, server := net.Pipe()
+		defer server.Close()
+		Expect(l.Conn(client)).To(Equal(client))
+	})
+
+	It("should stop transfers in flight when the window closes", func() {
+		window := func(from, to time.Duration) throttle.Schedule {
+			now := time.Now()
+			s, err := throttle.ParseSchedule([]string{now.Add(from).Format("15:04") + "-" + now.Add(to).Format("15:04")})
+			Expect(err).NotTo(HaveOccurred())
+			return s
+		}
+		open := throttle.NewLimiter(0, window(-time.Hour, time.Hour))
+		Expect(open.Open()).To(BeTrue())
+		data, err := ioutil.ReadAll(open.Reader(strings.NewReader("data")))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("data"))
+
+		closed := throttle.NewLimiter(0, window(time.Hour, 2*time.Hour))
+		Expect(closed.Open()).To(BeFalse())
+		r := closed.Reader(strings.NewReader("data"))
+		_, err = ioutil.ReadAll(r)
+		Expect(err).To(Equal(throttle.ErrClosed))
+		_, ok := r.(io.ReaderAt)
+		Expect(ok).To(BeTrue())
+		f, _ := ioutil.TempFile("", "s3s2-window")
+		defer os.Remove(f.Name())
+		_, err = closed.WriterAt(f).WriteAt([]byte("data"), 0)
+		Expect(err).To(Equal(throttle.ErrClosed))
+	})
+
+	It("should hold every connection to the rate together", func() {
+		l := throttle.NewLimiter(64<<10, nil)
+		start := time.Now()
+		done := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			client, server := net.Pipe()
+			go func() {
+				io.Copy(ioutil.Discard, server)
+				done <- struct{}{}
+			}()
+			go func(c net.Conn) {
+				c.Write(make([]byte, 16<<10))
+				c.Close()
+			}(l.Conn(client))
+		}
+		<-done
+		<-done
+		// 32 KiB at 64 KiB a second, less the quarter second burst.
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+	})
+})
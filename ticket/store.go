@@ -0,0 +1,173 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ticket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	storage "github.com/jemurai/s3s2/storage"
+	throttle "github.com/jemurai/s3s2/throttle"
+)
+
+// ErrWriteOnly is returned when reading through a ticket, which only
+// has links to upload.
+var ErrWriteOnly = errors.New("a ticket can only upload")
+
+// MaxSize is the largest object S3 accepts in a single PUT, which is
+// all a presigned upload link allows.
+const MaxSize = 5 * 1024 * 1024 * 1024
+
+// The headers a checksummed upload is made with.  The algorithm is
+// signed into the link and the checksum of the body sent alongside.
+const (
+	checksumAlgorithmHeader = "X-Amz-Sdk-Checksum-Algorithm"
+	checksumHeader          = "X-Amz-Checksum-Sha256"
+)
+
+// Store uploads a share through the links in a ticket.  The manifest
+// goes to its own slot and each other key is given the next free file
+// slot, keeping it if the upload is retried.
+type Store struct {
+	ticket  Ticket
+	client  *http.Client
+	limiter *throttle.Limiter
+
+	mu       sync.Mutex
+	assigned map[string]int
+}
+
+// Store uploads through the ticket with the client, or the default one.
+// Uploads stop when the limiter's transfer window closes.
+func (t Ticket) Store(client *http.Client, limiter *throttle.Limiter) *Store {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Store{ticket: t, client: client, limiter: limiter, assigned: map[string]int{}}
+}
+
+// slot finds the slot for a key.
+func (s *Store) slot(key string) (Slot, error) {
+	if key == s.ticket.Manifest.Key {
+		return s.ticket.Manifest, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.assigned[key]
+	if !ok {
+		i = len(s.assigned)
+		if i >= len(s.ticket.Files) {
+			return Slot{}, fmt.Errorf("the ticket has no upload slot left for %s, it allows %d files", key, len(s.ticket.Files))
+		}
+		s.assigned[key] = i
+	}
+	return s.ticket.Files[i], nil
+}
+
+// Put uploads the body to the slot for the key.  The object info has
+// the key of the slot, which is where the object really is.  Tags are
+// not set, as they were not signed into the link.  When the link was
+// made for SHA-256 checksums, the checksum of the body is sent with it
+// for S3 to check.
+func (s *Store) Put(key string, body io.Reader, opts storage.PutOptions) (storage.ObjectInfo, error) {
+	// S3 needs the length of a presigned upload up front.
+	var upload io.ReadSeeker
+	var size int64
+	if f, ok := body.(*os.File); ok {
+		stat, err := f.Stat()
+		if err != nil {
+			return storage.ObjectInfo{}, err
+		}
+		upload, size = f, stat.Size()
+	} else {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return storage.ObjectInfo{}, err
+		}
+		upload, size = bytes.NewReader(data), int64(len(data))
+	}
+	if size > MaxSize {
+		return storage.ObjectInfo{}, fmt.Errorf("unable to upload %s, it is %d bytes and an upload through a ticket can be at most %d (5 GB), share it with AWS credentials instead", key, size, int64(MaxSize))
+	}
+	slot, err := s.slot(key)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	checksum, err := storage.ReaderChecksum(upload)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	if _, err := upload.Seek(0, io.SeekStart); err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, slot.URL, s.limiter.Reader(upload))
+	if err != nil {
+		return storage.ObjectInfo{}, fmt.Errorf("invalid upload link for %s", slot.Key)
+	}
+	req.ContentLength = size
+	for name, value := range slot.Headers {
+		req.Header.Set(name, value)
+	}
+	if strings.EqualFold(req.Header.Get(checksumAlgorithmHeader), "SHA256") {
+		// S3 refuses the upload if the body does not match.
+		req.Header.Set(checksumHeader, checksum)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The error includes the link, which is a credential.
+		return storage.ObjectInfo{}, fmt.Errorf("unable to upload %s, %s", key, strings.Replace(err.Error(), slot.URL, "<link>", -1))
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return storage.ObjectInfo{}, fmt.Errorf("unable to upload %s, the ticket has expired or is not valid", key)
+	case resp.StatusCode/100 != 2:
+		return storage.ObjectInfo{}, fmt.Errorf("unable to upload %s, %s", key, resp.Status)
+	}
+	return storage.ObjectInfo{
+		Key:      slot.Key,
+		Size:     size,
+		ETag:     strings.Trim(resp.Header.Get("ETag"), `"`),
+		Checksum: checksum,
+	}, nil
+}
+
+// Get is not possible through a ticket.
+func (s *Store) Get(key string) (io.ReadCloser, error) {
+	return nil, ErrWriteOnly
+}
+
+// List is not possible through a ticket.
+func (s *Store) List(prefix string) ([]storage.ObjectInfo, error) {
+	return nil, ErrWriteOnly
+}
+
+// Stat is not possible through a ticket.
+func (s *Store) Stat(key string) (storage.ObjectInfo, error) {
+	return storage.ObjectInfo{}, ErrWriteOnly
+}
+
+// Delete is not possible through a ticket.
+func (s *Store) Delete(key string) error {
+	return ErrWriteOnly
+}
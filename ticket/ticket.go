@@ -0,0 +1,130 @@
+// Copyright © 2019 Matt Konda <mkonda@jemurai.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ticket lets a receiver hand a sender presigned links to upload
+// one share into the receiver's bucket, so the sender needs no AWS
+// credentials of their own.
+package ticket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	encrypt "github.com/jemurai/s3s2/encrypt"
+)
+
+// Slot is an object the sender may upload, and how.
+type Slot struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+	// Headers must be sent with the upload, as they were signed
+	// into the URL.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Ticket allows one share to be uploaded into a folder of a bucket
+// until it expires.  Files go to the numbered slots in any order; the
+// manifest records which file went where.
+type Ticket struct {
+	Folder  string    `json:"folder"`
+	Bucket  string    `json:"bucket"`
+	AwsKey  string    `json:"awskey,omitempty"`
+	SSE     string    `json:"sse,omitempty"`
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+	// Recipient is the fingerprint of the receiver's public key.
+	// Files must be encrypted for it.
+	Recipient string `json:"recipient"`
+	Manifest  Slot   `json:"manifest"`
+	Files     []Slot `json:"files"`
+	// Signature is the receiver's armored signature of the rest of
+	// the ticket, and Signer the fingerprint of the key that made it.
+	Signature string `json:"signature,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+}
+
+// SlotKey names the object for the nth file of a share, from 1.
+func SlotKey(folder string, n int) string {
+	return fmt.Sprintf("%s/file-%04d.zip.gpg", folder, n)
+}
+
+// Read a ticket from a file.
+func Read(file string) (Ticket, error) {
+	var t Ticket
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("unable to read the ticket %s, %v", file, err)
+	}
+	return t, nil
+}
+
+// Write the ticket to a file that only the owner can read, since its
+// links are credentials.
+func (t Ticket) Write(file string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// payload is what the signature covers: everything else.
+func (t Ticket) payload() []byte {
+	t.Signature, t.Signer = "", ""
+	data, _ := json.Marshal(t)
+	return data
+}
+
+// Sign the ticket with the receiver's keys.
+func (t *Ticket) Sign(pubkey string, privkey string) error {
+	signer, err := encrypt.Fingerprint(pubkey)
+	if err != nil {
+		return err
+	}
+	signature, err := encrypt.Sign(t.payload(), pubkey, privkey)
+	if err != nil {
+		return err
+	}
+	t.Signature, t.Signer = signature, signer
+	return nil
+}
+
+// Verify that the ticket was signed by the holder of the public key,
+// that the files are to be encrypted for that key, and that the ticket
+// has not expired.
+func (t Ticket) Verify(pubkey string, now time.Time) error {
+	if t.Signature == "" {
+		return errors.New("the ticket is not signed")
+	}
+	fingerprint, err := encrypt.Fingerprint(pubkey)
+	if err != nil {
+		return err
+	}
+	if t.Signer != fingerprint || t.Recipient != fingerprint {
+		return fmt.Errorf("the ticket is from %s for %s, not %s", t.Signer, t.Recipient, fingerprint)
+	}
+	if err := encrypt.Verify(t.payload(), t.Signature, pubkey); err != nil {
+		return fmt.Errorf("the ticket signature is not valid, %v", err)
+	}
+	if now.After(t.Expires) {
+		return fmt.Errorf("the ticket expired at %s", t.Expires.Local().Format(time.RFC1123))
+	}
+	return nil
+}
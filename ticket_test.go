@@ -0,0 +1,182 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jemurai/s3s2/encrypt"
+	"github.com/jemurai/s3s2/storage"
+	"github.com/jemurai/s3s2/ticket"
+)
+
+var _ = Describe("Upload tickets", func() {
+	var (
+		dir     string
+		server  *httptest.Server
+		mu      sync.Mutex
+		uploads map[string]string
+		t       ticket.Ticket
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "s3s2-ticket")
+		uploads = map[string]string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPut || r.Header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" {
+				http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+				return
+			}
+			if r.ContentLength < 0 {
+				http.Error(w, "MissingContentLength", http.StatusLengthRequired)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			uploads[r.URL.Path] = string(data)
+			mu.Unlock()
+			w.Header().Set("ETag", `"etag"`)
+		}))
+		headers := map[string]string{"X-Amz-Server-Side-Encryption": "aws:kms"}
+		slot := func(key string) ticket.Slot {
+			return ticket.Slot{Key: key, URL: server.URL + "/" + key + "?X-Amz-Signature=abc", Headers: headers}
+		}
+		t = ticket.Ticket{
+			Folder:   "acme_s3s2_1",
+			Bucket:   "inbox",
+			Issued:   time.Now(),
+			Expires:  time.Now().Add(time.Hour),
+			Manifest: slot("acme_s3s2_1/s3s2_manifest.json"),
+			Files:    []ticket.Slot{slot(ticket.SlotKey("acme_s3s2_1", 1)), slot(ticket.SlotKey("acme_s3s2_1", 2))},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should upload files to the slots in the ticket", func() {
+		store := t.Store(nil, nil)
+		file := filepath.Join(dir, "a.csv.zip.gpg")
+		ioutil.WriteFile(file, []byte("encrypted a"), 0600)
+
+		info, err := storage.PutFile(store, "acme_s3s2_1/a.csv.zip.gpg", file, storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Key).To(Equal("acme_s3s2_1/file-0001.zip.gpg"))
+		Expect(info.ETag).To(Equal("etag"))
+
+		// A retry goes to the same slot.
+		info, _ = storage.PutFile(store, "acme_s3s2_1/a.csv.zip.gpg", file, storage.PutOptions{})
+		Expect(info.Key).To(Equal("acme_s3s2_1/file-0001.zip.gpg"))
+
+		info, err = storage.WriteObject(store, "acme_s3s2_1/b.csv.zip.gpg", []byte("encrypted b"), storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Key).To(Equal("acme_s3s2_1/file-0002.zip.gpg"))
+
+		_, err = storage.WriteObject(store, "acme_s3s2_1/s3s2_manifest.json", []byte("{}"), storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(uploads).To(Equal(map[string]string{
+			"/acme_s3s2_1/file-0001.zip.gpg":  "encrypted a",
+			"/acme_s3s2_1/file-0002.zip.gpg":  "encrypted b",
+			"/acme_s3s2_1/s3s2_manifest.json": "{}",
+		}))
+	})
+
+	It("should send the checksum when the link was made for one", func() {
+		var sent []string
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			sent = append(sent, r.Header.Get("X-Amz-Checksum-Sha256"))
+			mu.Unlock()
+		})
+		t.Files[1].Headers = map[string]string{"X-Amz-Sdk-Checksum-Algorithm": "SHA256"}
+		store := t.Store(nil, nil)
+
+		a, err := storage.WriteObject(store, "acme_s3s2_1/a", []byte("a"), storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		b, err := storage.WriteObject(store, "acme_s3s2_1/b", []byte("b"), storage.PutOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sent).To(Equal([]string{"", b.Checksum}))
+		// sha256("b")
+		Expect(b.Checksum).To(Equal("PiPoFgA5WUoziU9lZOGxNIu9egCI1CxKy3PurtWcAJ0="))
+		Expect(a.Checksum).NotTo(BeEmpty())
+	})
+
+	It("should stop when the slots run out", func() {
+		store := t.Store(nil, nil)
+		for _, name := range []string{"a", "b"} {
+			_, err := storage.WriteObject(store, "acme_s3s2_1/"+name, []byte(name), storage.PutOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := storage.WriteObject(store, "acme_s3s2_1/c", []byte("c"), storage.PutOptions{})
+		Expect(err).To(MatchError(ContainSubstring("no upload slot")))
+	})
+
+	It("should refuse files too big for a single upload", func() {
+		file := filepath.Join(dir, "big.zip.gpg")
+		f, _ := os.Create(file)
+		f.Truncate(ticket.MaxSize + 1)
+		f.Close()
+		_, err := storage.PutFile(t.Store(nil, nil), "acme_s3s2_1/big.zip.gpg", file, storage.PutOptions{})
+		Expect(err).To(MatchError(ContainSubstring("at most")))
+		Expect(uploads).To(BeEmpty())
+	})
+
+	It("should not leak the links in errors", func() {
+		t.Files[0].Headers = nil
+		_, err := storage.WriteObject(t.Store(nil, nil), "acme_s3s2_1/a", []byte("a"), storage.PutOptions{})
+		Expect(err).To(MatchError(ContainSubstring("expired or is not valid")))
+		Expect(err.Error()).NotTo(ContainSubstring("X-Amz-Signature"))
+
+		_, err = t.Store(nil, nil).Get("acme_s3s2_1/a")
+		Expect(err).To(Equal(ticket.ErrWriteOnly))
+	})
+
+	Describe("Signatures", func() {
+		var pub, priv string
+
+		BeforeEach(func() {
+			encrypt.GenerateKeys(dir, "receiver", 1024)
+			pub = filepath.Join(dir, "receiver.pubkey")
+			priv = filepath.Join(dir, "receiver.privkey")
+			t.Recipient, _ = encrypt.Fingerprint(pub)
+			Expect(t.Sign(pub, priv)).To(Succeed())
+		})
+
+		It("should verify a ticket from the receiver", func() {
+			file := filepath.Join(dir, "ticket.json")
+			Expect(t.Write(file)).To(Succeed())
+			stat, _ := os.Stat(file)
+			Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			read, err := ticket.Read(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(read.Verify(pub, time.Now())).To(Succeed())
+		})
+
+		It("should reject a ticket that was changed or has expired", func() {
+			changed := t
+			changed.Bucket = "elsewhere"
+			Expect(changed.Verify(pub, time.Now())).To(MatchError(ContainSubstring("signature")))
+
+			Expect(t.Verify(pub, time.Now().Add(2*time.Hour))).To(MatchError(ContainSubstring("expired")))
+		})
+
+		It("should reject a ticket for another key", func() {
+			encrypt.GenerateKeys(dir, "other", 1024)
+			err := t.Verify(filepath.Join(dir, "other.pubkey"), time.Now())
+			Expect(err).To(HaveOccurred())
+			Expect(strings.Contains(err.Error(), "not")).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,240 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/jemurai/s3s2/options"
+	s3helper "github.com/jemurai/s3s2/s3"
+	"github.com/jemurai/s3s2/storage"
+)
+
+// The transfer benchmarks compare the way shares used to be moved, with
+// a new session, uploader or downloader and default settings for every
+// file, against one tuned Store shared by all of the files.  They run
+// against a fake S3 that adds a little latency to every request:
+//
+//   go test -run none -bench Transfer -benchtime 3x
+
+const (
+	benchFiles    = 8
+	benchFileSize = 24 * 1024 * 1024
+	benchLatency  = 5 * time.Millisecond
+)
+
+// fakeS3 accepts uploads, discarding the data, and serves the same
+// object for every download, with ranges.
+func fakeS3(object []byte) *httptest.Server {
+	var mu sync.Mutex
+	uploads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchLatency)
+		io.Copy(ioutil.Discard, r.Body)
+		query := r.URL.Query()
+		switch {
+		case r.Method == "POST" && hasQuery(query, "uploads"):
+			mu.Lock()
+			uploads++
+			id := strconv.Itoa(uploads)
+			mu.Unlock()
+			fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+		case r.Method == "POST":
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == "PUT":
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == "HEAD":
+			w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		case r.Method == "GET":
+			start, end := 0, len(object)-1
+			if rng := r.Header.Get("Range"); rng != "" {
+				fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+				if end >= len(object) {
+					end = len(object) - 1
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(object)))
+				w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+				w.WriteHeader(http.StatusPartialContent)
+			}
+			w.Write(object[start : end+1])
+		}
+	}))
+}
+
+func hasQuery(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// benchOptions are the settings every benchmark uses, so the per-file
+// session benchmarks and the shared store ones differ only in the session.
+// Upload state goes in dir, not $HOME.
+func benchOptions(server *httptest.Server, dir string) options.Options {
+	os.Setenv("AWS_ACCESS_KEY_ID", "bench")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "bench")
+	return options.Options{
+		Bucket:         "bench",
+		Region:         "us-east-1",
+		Endpoint:       server.URL,
+		PathStyle:      true,
+		PartSize:       8,
+		Concurrency:    8,
+		MaxConnections: 64,
+		StateDir:       filepath.Join(dir, "state"),
+	}
+}
+
+func benchFilesIn(b *testing.B, dir string) []string {
+	data := bytes.Repeat([]byte("s3s2"), benchFileSize/4)
+	var files []string
+	for i := 0; i < benchFiles; i++ {
+		fn := filepath.Join(dir, fmt.Sprintf("file%d.zip.gpg", i))
+		if err := ioutil.WriteFile(fn, data, 0600); err != nil {
+			b.Fatal(err)
+		}
+		files = append(files, fn)
+	}
+	return files
+}
+
+// eachFile runs fn for every file at once, as share and decrypt do.
+func eachFile(b *testing.B, files []string, fn func(i int, file string) error) {
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			if err := fn(i, file); err != nil {
+				b.Error(err)
+			}
+		}(i, file)
+	}
+	wg.Wait()
+}
+
+func BenchmarkTransferUploadPerFileSession(b *testing.B) {
+	server := fakeS3(nil)
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "s3s2-bench")
+	defer os.RemoveAll(dir)
+	opts := benchOptions(server, dir)
+	files := benchFilesIn(b, dir)
+
+	b.SetBytes(benchFiles * benchFileSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		eachFile(b, files, func(i int, file string) error {
+			sess := session.Must(session.NewSession(&aws.Config{
+				Region:           aws.String(opts.Region),
+				Endpoint:         aws.String(opts.Endpoint),
+				S3ForcePathStyle: aws.Bool(true),
+				DisableSSL:       aws.Bool(true),
+			}))
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+				u.PartSize = s3helper.PartSize(opts)
+				u.Concurrency = opts.Concurrency
+			}).Upload(&s3manager.UploadInput{
+				Bucket: aws.String(opts.Bucket),
+				Key:    aws.String(filepath.Base(file)),
+				Body:   f,
+			})
+			return err
+		})
+	}
+}
+
+func BenchmarkTransferUploadSharedStore(b *testing.B) {
+	server := fakeS3(nil)
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "s3s2-bench")
+	defer os.RemoveAll(dir)
+	files := benchFilesIn(b, dir)
+	store, err := s3helper.NewStore(benchOptions(server, dir))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(benchFiles * benchFileSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		eachFile(b, files, func(i int, file string) error {
+			_, err := storage.PutFile(store, filepath.Base(file), file, storage.PutOptions{})
+			return err
+		})
+	}
+}
+
+func BenchmarkTransferDownloadPerFileSession(b *testing.B) {
+	server := fakeS3(bytes.Repeat([]byte("s3s2"), benchFileSize/4))
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "s3s2-bench")
+	defer os.RemoveAll(dir)
+	opts := benchOptions(server, dir)
+	files := make([]string, benchFiles)
+
+	b.SetBytes(benchFiles * benchFileSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		eachFile(b, files, func(i int, _ string) error {
+			sess := session.Must(session.NewSession(&aws.Config{
+				Region:           aws.String(opts.Region),
+				Endpoint:         aws.String(opts.Endpoint),
+				S3ForcePathStyle: aws.Bool(true),
+				DisableSSL:       aws.Bool(true),
+			}))
+			f, err := os.Create(filepath.Join(dir, strconv.Itoa(i)))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+				d.PartSize = s3helper.PartSize(opts)
+				d.Concurrency = opts.Concurrency
+			}).Download(f, &s3.GetObjectInput{
+				Bucket: aws.String(opts.Bucket),
+				Key:    aws.String(strconv.Itoa(i)),
+			})
+			return err
+		})
+	}
+}
+
+func BenchmarkTransferDownloadSharedStore(b *testing.B) {
+	server := fakeS3(bytes.Repeat([]byte("s3s2"), benchFileSize/4))
+	defer server.Close()
+	dir, _ := ioutil.TempDir("", "s3s2-bench")
+	defer os.RemoveAll(dir)
+	files := make([]string, benchFiles)
+	store, err := s3helper.NewStore(benchOptions(server, dir))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(benchFiles * benchFileSize)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		eachFile(b, files, func(i int, _ string) error {
+			return storage.GetFile(store, strconv.Itoa(i), filepath.Join(dir, strconv.Itoa(i)))
+		})
+	}
+}